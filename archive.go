@@ -0,0 +1,51 @@
+package shorturl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// TargetDead reports whether the target of the short url is gone, either
+// marked dead by an admin or found dead by the link checker.
+func (s *Shorturl) TargetDead() bool {
+	return s.MarkedDead || s.Check.Dead()
+}
+
+// archiveFallback reports whether the short url redirects to its archived
+// copy instead of the target.
+func (c Config) archiveFallback(s *Shorturl) bool {
+	return c.ArchiveFallback && s.ArchiveURL != "" && s.TargetDead()
+}
+
+// ParseArchiveList reads archive URLs of short urls, one per line as the
+// short code followed by the archive URL, e.g.
+//
+//	za https://web.archive.org/web/2015/https://www.example.com/
+//
+// Empty lines and lines starting with # are ignored.
+func ParseArchiveList(r io.Reader) ([]*Shorturl, error) {
+	var list []*Shorturl
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want short code and archive URL", line)
+		}
+		id, err := parseUID(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid short code %q", line, fields[0])
+		}
+		u, err := url.Parse(fields[1])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("line %d: invalid archive URL %q", line, fields[1])
+		}
+		list = append(list, &Shorturl{ID: id, ArchiveURL: fields[1]})
+	}
+	return list, scanner.Err()
+}
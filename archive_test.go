@@ -0,0 +1,59 @@
+package shorturl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseArchiveList(t *testing.T) {
+	list, err := ParseArchiveList(strings.NewReader(`# archive.org snapshots
+za https://web.archive.org/web/2015/http://www.example.com/
+
+a  http://archive.example/a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != 1270 || list[1].ArchiveURL != "http://archive.example/a" {
+		t.Errorf("ParseArchiveList = %+v", list)
+	}
+	for _, invalid := range []string{"za", "za ftp://archive.example/", "-! https://archive.example/"} {
+		if _, err := ParseArchiveList(strings.NewReader(invalid)); err == nil {
+			t.Errorf("ParseArchiveList(%q) succeeded", invalid)
+		}
+	}
+}
+
+var archiveFallbackTests = []struct {
+	name     string
+	fallback bool
+	s        Shorturl
+	want     bool
+}{
+	{"checked dead", true, Shorturl{Check: &LinkCheck{Status: http.StatusNotFound}, ArchiveURL: "https://archive.example/"}, true},
+	{"marked dead", true, Shorturl{MarkedDead: true, ArchiveURL: "https://archive.example/"}, true},
+	{"fallback off", false, Shorturl{MarkedDead: true, ArchiveURL: "https://archive.example/"}, false},
+	{"no archive", true, Shorturl{Check: &LinkCheck{Status: http.StatusNotFound}}, false},
+	{"alive", true, Shorturl{Check: &LinkCheck{Status: http.StatusOK}, ArchiveURL: "https://archive.example/"}, false},
+}
+
+func TestArchiveFallback(t *testing.T) {
+	for _, c := range archiveFallbackTests {
+		config := Config{ArchiveFallback: c.fallback}
+		if got := config.archiveFallback(&c.s); got != c.want {
+			t.Errorf("%s: archiveFallback = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPreviewArchived(t *testing.T) {
+	w := httptest.NewRecorder()
+	s := &Shorturl{ID: 10, URL: "http://gone.example/", MarkedDead: true, ArchiveURL: "https://archive.example/gone"}
+	previewPage(previewContext{Shorturl: s, Archived: true}).ServeHTTP(w, httptest.NewRequest("GET", "http://yx.fi/p/a", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "redirects to an") || !strings.Contains(body, `href="https://archive.example/gone"`) {
+		t.Errorf("preview page does not note the archive fallback:\n%s", body)
+	}
+}
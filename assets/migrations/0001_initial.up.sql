@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS shorturl (
+    id SERIAL PRIMARY KEY,
+    url text,
+    ts timestamp without time zone DEFAULT now() NOT NULL,
+    host text,
+    cookie text
+);
@@ -0,0 +1,2 @@
+DROP INDEX shorturl_canonical;
+ALTER TABLE shorturl DROP COLUMN canonical;
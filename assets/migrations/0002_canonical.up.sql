@@ -0,0 +1,2 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS canonical text;
+CREATE INDEX IF NOT EXISTS shorturl_canonical ON shorturl (canonical);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS ratelimit (
+    key text PRIMARY KEY,
+    tokens double precision NOT NULL,
+    updated timestamp with time zone NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX shorturl_cookie;
+ALTER TABLE shorturl DROP COLUMN disabled;
+ALTER TABLE shorturl DROP COLUMN hits;
@@ -0,0 +1,3 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS hits bigint DEFAULT 0 NOT NULL;
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS disabled boolean DEFAULT false NOT NULL;
+CREATE INDEX IF NOT EXISTS shorturl_cookie ON shorturl (cookie);
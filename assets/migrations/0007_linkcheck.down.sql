@@ -0,0 +1,4 @@
+ALTER TABLE shorturl DROP COLUMN checked;
+ALTER TABLE shorturl DROP COLUMN check_redirects;
+ALTER TABLE shorturl DROP COLUMN check_error;
+ALTER TABLE shorturl DROP COLUMN check_status;
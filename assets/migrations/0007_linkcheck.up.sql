@@ -0,0 +1,4 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS check_status smallint;
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS check_error text;
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS check_redirects text[];
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS checked timestamp with time zone;
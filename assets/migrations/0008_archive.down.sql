@@ -0,0 +1,2 @@
+ALTER TABLE shorturl DROP COLUMN archive_url;
+ALTER TABLE shorturl DROP COLUMN dead;
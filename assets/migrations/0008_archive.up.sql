@@ -0,0 +1,2 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS dead boolean DEFAULT false NOT NULL;
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS archive_url text;
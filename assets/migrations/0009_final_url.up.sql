@@ -0,0 +1 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS final_url text;
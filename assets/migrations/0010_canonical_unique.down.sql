@@ -0,0 +1,2 @@
+DROP INDEX shorturl_canonical;
+CREATE INDEX shorturl_canonical ON shorturl (canonical);
@@ -0,0 +1,10 @@
+-- Short urls added before canonical urls were recorded get theirs in Go
+-- before these statements, see canonicalizeURLs.
+-- Disabled short urls are not used for new short urls to the same URL.
+UPDATE shorturl SET canonical = NULL WHERE disabled;
+-- Only the oldest of short urls with the same canonical url is found by it.
+UPDATE shorturl s SET canonical = NULL
+WHERE canonical IS NOT NULL
+    AND id > (SELECT min(id) FROM shorturl d WHERE d.canonical = s.canonical);
+DROP INDEX IF EXISTS shorturl_canonical;
+CREATE UNIQUE INDEX shorturl_canonical ON shorturl (canonical);
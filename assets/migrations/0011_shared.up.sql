@@ -0,0 +1 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS shared boolean DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS check_failures integer DEFAULT 0 NOT NULL;
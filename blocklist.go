@@ -0,0 +1,168 @@
+package shorturl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blocklist is a list of malicious domains and URL prefixes loaded from
+// local feed files. Links whose target matches are not redirected to
+// directly; a warning page is shown instead.
+//
+// Feed files are either in hosts file format ("0.0.0.0 evil.example") or
+// plain text with one domain or URL prefix per line. Lines starting with #
+// are ignored, and a trailing # comment is used as the reason for the
+// listing.
+type Blocklist struct {
+	paths []string
+
+	mu       sync.RWMutex
+	domains  map[string]string
+	prefixes map[string]string
+	modified map[string]time.Time
+}
+
+// LoadBlocklist reads the blocklist from the feed files.
+func LoadBlocklist(paths ...string) (*Blocklist, error) {
+	b := &Blocklist{paths: paths}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload reads the feed files again if any of them has changed.
+func (b *Blocklist) Reload() error {
+	modified := make(map[string]time.Time)
+	changed := false
+	for _, path := range b.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modified[path] = fi.ModTime()
+		b.mu.RLock()
+		if !fi.ModTime().Equal(b.modified[path]) {
+			changed = true
+		}
+		b.mu.RUnlock()
+	}
+	if !changed {
+		return nil
+	}
+	domains := make(map[string]string)
+	prefixes := make(map[string]string)
+	for _, path := range b.paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = parseBlocklist(f, filepath.Base(path), domains, prefixes)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	b.mu.Lock()
+	b.domains, b.prefixes, b.modified = domains, prefixes, modified
+	b.mu.Unlock()
+	return nil
+}
+
+// Refresh reloads the feed files every interval. It never returns and is
+// meant to be run in its own goroutine.
+func (b *Blocklist) Refresh(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := b.Reload(); err != nil {
+			log.Printf("reloading blocklist: %v", err)
+		}
+	}
+}
+
+// Match checks the URL against the blocklist and returns the reason it is
+// listed. A nil Blocklist matches nothing.
+func (b *Blocklist) Match(rawurl string) (reason string, ok bool) {
+	if b == nil {
+		return "", false
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", false
+	}
+	// Prefixes are compared in canonical form, so that e.g. the case of
+	// the host or an explicit default port does not avoid a match.
+	target := rawurl
+	if canonical, err := Canonicalize(rawurl, false); err == nil {
+		target = canonical
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for prefix, reason := range b.prefixes {
+		if strings.HasPrefix(target, prefix) {
+			return reason, true
+		}
+	}
+	// Listing a domain also lists all of its subdomains.
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for host != "" {
+		if reason, ok := b.domains[host]; ok {
+			return reason, true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return "", false
+}
+
+// parseBlocklist reads entries from a feed into domains and prefixes. The
+// reason recorded for entries without a comment names the feed.
+func parseBlocklist(r io.Reader, feed string, domains, prefixes map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		reason := "listed in " + feed
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			if comment := strings.TrimSpace(line[i+1:]); comment != "" {
+				reason = comment
+			}
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Hosts file format: address followed by one or more host names.
+		if net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, entry := range fields {
+			if strings.Contains(entry, "://") {
+				if canonical, err := Canonicalize(entry, false); err == nil {
+					entry = canonical
+				}
+				prefixes[entry] = reason
+				continue
+			}
+			entry = strings.TrimSuffix(strings.ToLower(entry), ".")
+			switch entry {
+			case "localhost", "localhost.localdomain", "broadcasthost", "local":
+				// Standard entries found in hosts files.
+				continue
+			}
+			domains[entry] = reason
+		}
+	}
+	return scanner.Err()
+}
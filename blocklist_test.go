@@ -0,0 +1,95 @@
+package shorturl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = `# test feed
+0.0.0.0 localhost
+0.0.0.0 evil.example
+127.0.0.1 phish.example other.example # credential phishing
+malware.example
+https://pages.example/~attacker/ # hosted malware
+HTTP://Bad.Example:80/files/ # hosted malware
+`
+
+var blocklistTests = []struct {
+	url    string
+	reason string
+}{
+	{"http://evil.example/", "listed in feed.txt"},
+	{"http://EVIL.example./path", "listed in feed.txt"},
+	{"https://www.evil.example/", "listed in feed.txt"},
+	{"https://phish.example/login", "credential phishing"},
+	{"https://other.example/", "credential phishing"},
+	{"ftp://malware.example/x.exe", "listed in feed.txt"},
+	{"https://pages.example/~attacker/index.html", "hosted malware"},
+	{"HTTPS://Pages.Example:443/~attacker/", "hosted malware"},
+	{"http://bad.example/files/x.exe", "hosted malware"},
+	{"https://pages.example/~someone/", ""},
+	{"https://notevil.example/", ""},
+	{"http://localhost/", ""},
+}
+
+func TestBlocklistMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "feed.txt")
+	if err := ioutil.WriteFile(path, []byte(testFeed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range blocklistTests {
+		reason, ok := b.Match(c.url)
+		if ok != (c.reason != "") || reason != c.reason {
+			t.Errorf("Match(%s) = %q, %v, want %q", c.url, reason, ok, c.reason)
+		}
+	}
+
+	// Changed feed is picked up on reload.
+	if err := ioutil.WriteFile(path, []byte("notevil.example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Match("https://notevil.example/"); !ok {
+		t.Error("reloaded entry did not match")
+	}
+	if _, ok := b.Match("http://evil.example/"); ok {
+		t.Error("removed entry still matches")
+	}
+}
+
+func TestPreviewWarning(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Shorturl{ID: 10, URL: "http://evil.example/"}
+	err := templates["en"]["preview.html"].Execute(&buf, map[string]interface{}{
+		"Protocol": "https://",
+		"Domain":   "yx.fi",
+		"Prefs":    Prefs{},
+		"Data":     previewContext{Shorturl: s, Warning: "credential phishing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "credential phishing") {
+		t.Error("warning reason not shown on preview page")
+	}
+}
@@ -0,0 +1,110 @@
+package shorturl
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts are removed from canonical URLs.
+var defaultPorts = map[string]string{
+	"http":   "80",
+	"https":  "443",
+	"ftp":    "21",
+	"ftps":   "990",
+	"gopher": "70",
+}
+
+// trackingParams are query parameters that only identify the campaign or
+// click that brought the user to the page. They are removed from canonical
+// URLs when tracking parameter stripping is enabled. Parameters ending in
+// "_" match by prefix.
+var trackingParams = []string{
+	"utm_",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"igshid",
+	"yclid",
+	"_hsenc",
+	"_hsmi",
+}
+
+// Canonicalize returns the canonical form of the URL, used to find earlier
+// short urls for the same target. Scheme and host are case folded,
+// internationalized domain names are converted to punycode, default ports
+// and empty fragments are removed, and if stripTracking is set, so are
+// tracking query parameters.
+func Canonicalize(rawurl string, stripTracking bool) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Opaque != "" {
+		// e.g. spotify:track:id has no host to normalize.
+		return u.String(), nil
+	}
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return "", errors.New("URL has no host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else if host, err = idna.Lookup.ToASCII(strings.TrimSuffix(host, ".")); err != nil {
+		return "", err
+	}
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	if u.Path == "" && (u.Scheme == "http" || u.Scheme == "https") {
+		u.Path = "/"
+	}
+	if stripTracking {
+		u.RawQuery = stripTrackingParams(u.RawQuery)
+	}
+	// Empty query and fragment ("?" and "#") are dropped when formatting.
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// stripTrackingParams removes tracking parameters from the query, keeping
+// the order and encoding of the remaining parameters.
+func stripTrackingParams(query string) string {
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		name := param
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name = param[:i]
+		}
+		if name, err := url.QueryUnescape(name); err == nil && isTrackingParam(name) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range trackingParams {
+		if name == p || strings.HasSuffix(p, "_") && strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
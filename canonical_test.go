@@ -0,0 +1,41 @@
+package shorturl
+
+import "testing"
+
+var canonicalTests = []struct {
+	in            string
+	stripTracking bool
+	want          string
+}{
+	{"http://example.com/a", false, "http://example.com/a"},
+	{"HTTP://Example.COM/a", false, "http://example.com/a"},
+	{"http://example.com:80/a", false, "http://example.com/a"},
+	{"https://example.com:443/a", false, "https://example.com/a"},
+	{"https://example.com:8443/a", false, "https://example.com:8443/a"},
+	{"http://example.com/a#", false, "http://example.com/a"},
+	{"http://example.com/a?", false, "http://example.com/a"},
+	{"http://example.com/a#top", false, "http://example.com/a#top"},
+	{"http://example.com", false, "http://example.com/"},
+	{"http://example.com./", false, "http://example.com/"},
+	{"http://bücher.example/", false, "http://xn--bcher-kva.example/"},
+	{"http://[::1]:80/", false, "http://[::1]/"},
+	{"http://[::1]:8080/", false, "http://[::1]:8080/"},
+	{"http://example.com/Path?Q=1", false, "http://example.com/Path?Q=1"},
+	{"http://example.com/?utm_source=x&id=1&fbclid=y", false, "http://example.com/?utm_source=x&id=1&fbclid=y"},
+	{"http://example.com/?utm_source=x&id=1&fbclid=y", true, "http://example.com/?id=1"},
+	{"http://example.com/?UTM_Medium=x", true, "http://example.com/"},
+	{"spotify:track:abc", false, "spotify:track:abc"},
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, c := range canonicalTests {
+		got, err := Canonicalize(c.in, c.stripTracking)
+		if err != nil {
+			t.Errorf("Canonicalize(%s) failed: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Canonicalize(%s, %v) = %s, want %s", c.in, c.stripTracking, got, c.want)
+		}
+	}
+}
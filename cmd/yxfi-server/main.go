@@ -1,15 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joneskoo/shorturl-go"
 )
 
+// shutdownTimeout is how long requests in progress may take to finish when
+// the server is stopped.
+const shutdownTimeout = 10 * time.Second
+
 var allowedURLSchemes = []string{"http", "https", "ftp", "ftps", "feed", "gopher", "magnet", "spotify"}
 
 // globals
@@ -18,25 +33,407 @@ var (
 	secure        bool
 	csrfStateFile = "csrf.secret"
 	listenAddr    = "127.0.0.1:39284"
+	rateLimit     = "memory"
+	rateLimits    = shorturl.DefaultRateLimits
+	proxies       = "127.0.0.0/8,::1/128"
+	blocklists    string
+	blocklistPoll = 5 * time.Minute
+	allowCreate   bool
+	stripTracking bool
+	cookieKeys    = "cookie.keys"
+	cookieEncrypt bool
+	creatorGrace  = 24 * time.Hour
+	redirectCode  = http.StatusFound
+	permanentAge  = 30 * 24 * time.Hour
+	linkCheck     time.Duration
+	linkCheckConc = 4
+	linkCheckWait = 2 * time.Second
+	deadLinks     bool
+	archiveMode   bool
+	archiveImport string
+	resolveHops   = 5
+	dbTimeout     = 5 * time.Second
+	dbMaxOpen     = 20
+	dbMaxIdle     = 5
+	dbLifetime    = 30 * time.Minute
+	replicas      stringList
+	snapshotFile  string
+	snapshotPoll  = time.Hour
+	metricsAddr   string
+	frozenFile    string
+	templatesDir  string
+	devMode       bool
+	migrateForce  bool
 )
 
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 func main() {
 	flag.BoolVar(&secure, "secure", false, "use https URLs and set secure flag in cookies")
 	connstring := flag.String("connstring", "user=joneskoo dbname=joneskoo sslmode=disable", "PostgreSQL connection string")
 	flag.StringVar(&csrfStateFile, "csrf-file", csrfStateFile, "file to store CSRF secret in")
 	flag.StringVar(&listenAddr, "listen", listenAddr, "listen on [host]:port")
+	flag.StringVar(&rateLimit, "ratelimit", rateLimit, "rate limiter backend: memory, db (shared between servers) or off")
+	flag.Var(&rateLimits.Redirect, "ratelimit-redirect", "redirect rate limit per client as rate:burst")
+	flag.Var(&rateLimits.Preview, "ratelimit-preview", "preview rate limit per client as rate:burst")
+	flag.Var(&rateLimits.NotFound, "ratelimit-notfound", "not found rate limit per client as rate:burst")
+	flag.Var(&rateLimits.Create, "ratelimit-create", "create rate limit per client as rate:burst")
+	flag.StringVar(&proxies, "trusted-proxies", proxies, "comma separated networks of reverse proxies trusted to set X-Forwarded-For")
+	flag.StringVar(&blocklists, "blocklist", blocklists, "comma separated blocklist feed files of malicious domains and URL prefixes")
+	flag.DurationVar(&blocklistPoll, "blocklist-refresh", blocklistPoll, "interval to check blocklist files for changes")
+	flag.BoolVar(&allowCreate, "allow-create", allowCreate, "allow adding new short urls")
+	flag.BoolVar(&stripTracking, "strip-tracking", stripTracking, "ignore tracking query parameters when looking for duplicate short urls")
+	flag.StringVar(&cookieKeys, "cookie-keys", cookieKeys, "file of cookie signing keys in hex, one per line, newest first; created if missing")
+	flag.BoolVar(&cookieEncrypt, "cookie-encrypt", cookieEncrypt, "encrypt cookie values in addition to signing them")
+	flag.DurationVar(&creatorGrace, "creator-grace", creatorGrace, "time after adding a short url that its creator may delete or disable it")
+	flag.IntVar(&redirectCode, "redirect-status", redirectCode, "default redirect status code: 301, 302, 307 or 308")
+	flag.DurationVar(&permanentAge, "permanent-max-age", permanentAge, "time permanent redirects may be cached by browsers and CDNs")
+	flag.DurationVar(&linkCheck, "linkcheck", linkCheck, "check that targets still respond this often; 0 disables link checks")
+	flag.IntVar(&linkCheckConc, "linkcheck-concurrency", linkCheckConc, "number of domains to check at the same time")
+	flag.DurationVar(&linkCheckWait, "linkcheck-delay", linkCheckWait, "time between link checks to the same domain")
+	flag.BoolVar(&deadLinks, "dead-links", deadLinks, "print report of short urls with dead targets and exit")
+	flag.BoolVar(&archiveMode, "archive-fallback", archiveMode, "redirect to the archived copy when the target is dead")
+	flag.StringVar(&archiveImport, "import-archive", archiveImport, "import archive URLs from file of short code and URL per line and exit")
+	flag.IntVar(&resolveHops, "resolve-hops", resolveHops, "redirects to follow to find the final destination of new short urls; 0 disables")
+	flag.DurationVar(&dbTimeout, "db-timeout", dbTimeout, "time limit of database queries made for requests; 0 disables")
+	flag.IntVar(&dbMaxOpen, "db-max-open", dbMaxOpen, "maximum number of open database connections; 0 is unlimited")
+	flag.IntVar(&dbMaxIdle, "db-max-idle", dbMaxIdle, "maximum number of idle database connections")
+	flag.DurationVar(&dbLifetime, "db-conn-lifetime", dbLifetime, "time after which database connections are closed and reopened; 0 keeps them")
+	flag.Var(&replicas, "replica", "PostgreSQL connection string of a read-only replica used for redirects and previews; may be repeated")
+	flag.StringVar(&snapshotFile, "snapshot", snapshotFile, "file of short url snapshot used for redirects while the database is unavailable")
+	flag.DurationVar(&snapshotPoll, "snapshot-refresh", snapshotPoll, "interval to update the snapshot from the database")
+	flag.StringVar(&metricsAddr, "metrics-listen", metricsAddr, "serve metrics at /debug/vars on [host]:port; keep it private")
+	flag.StringVar(&frozenFile, "frozen", frozenFile, "serve the short urls exported to file with the export command, without database; replaces -connstring")
+	flag.StringVar(&templatesDir, "templates-dir", templatesDir, "directory of templates used instead of the built in templates of the same name")
+	flag.BoolVar(&devMode, "dev", devMode, "development mode: reload templates from -templates-dir when they change")
+	flag.BoolVar(&migrateForce, "force", migrateForce, "allow migrate down to revert the initial migration, which drops all short urls")
 	flag.Parse()
 	log.Printf("Starting server, os.Args=%s", strings.Join(os.Args, " "))
+	if !shorturl.ValidRedirectStatus(redirectCode) {
+		log.Fatalf("Invalid redirect status %d", redirectCode)
+	}
+
+	if flag.Arg(0) == "check-templates" {
+		if err := shorturl.CheckTemplates(templatesDir); err != nil {
+			log.Fatalf("Checking templates: %v", err)
+		}
+		log.Print("Templates are valid")
+		return
+	}
+	if templatesDir != "" {
+		if err := shorturl.LoadTemplates(templatesDir); err != nil {
+			log.Fatalf("Loading templates: %v", err)
+		}
+		if devMode {
+			go shorturl.WatchTemplates(templatesDir, time.Second)
+		}
+	}
 
 	var err error
-	if db, err = shorturl.Open(*connstring); err != nil {
-		log.Fatalf("Connecting to database: %v", err)
+	var store shorturl.Store
+	if frozenFile != "" {
+		if err := checkFrozenFlags(); err != nil {
+			log.Fatal(err)
+		}
+		snapshot, err := shorturl.LoadSnapshot(frozenFile)
+		if err != nil {
+			log.Fatalf("Loading short urls: %v", err)
+		}
+		if snapshot.Created().IsZero() {
+			log.Fatalf("Loading short urls: %s does not exist", frozenFile)
+		}
+		log.Printf("Serving %d short urls exported at %s without database", snapshot.Len(), snapshot.Created())
+		store = snapshot
+	} else {
+		if db, err = shorturl.Open(*connstring); err != nil {
+			log.Fatalf("Connecting to database: %v", err)
+		}
+		for _, r := range replicas {
+			if err := db.AddReplica(r); err != nil {
+				log.Fatalf("Connecting to replica: %v", err)
+			}
+		}
+		db.QueryTimeout = dbTimeout
+		db.SetConnLimits(dbMaxOpen, dbMaxIdle, dbLifetime)
+		switch flag.Arg(0) {
+		case "migrate":
+			if err := migrate(flag.Arg(1)); err != nil {
+				log.Fatalf("Migrating database: %v", err)
+			}
+			return
+		case "export":
+			if err := export(flag.Arg(1)); err != nil {
+				log.Fatalf("Exporting short urls: %v", err)
+			}
+			return
+		}
+		switch err := db.CheckSchema(); {
+		case err == nil:
+		case err != shorturl.ErrSchemaBehind && snapshotFile != "":
+			// Redirects are served from the snapshot until the database
+			// is back.
+			log.Printf("Checking database schema: %v", err)
+		default:
+			log.Fatalf("Checking database schema: %v", err)
+		}
+		if archiveImport != "" {
+			if err := importArchive(archiveImport); err != nil {
+				log.Fatalf("Importing archive URLs: %v", err)
+			}
+			return
+		}
+		if deadLinks {
+			links, err := db.CheckedLinks()
+			if err != nil {
+				log.Fatalf("Listing checked links: %v", err)
+			}
+			if err := shorturl.WriteDeadLinkReport(os.Stdout, links); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		store = db
+	}
+
+	c := shorturl.Config{
+		Secure:         secure,
+		AllowCreate:    allowCreate,
+		AllowedSchemes: allowedURLSchemes,
+		StripTracking:  stripTracking,
+
+		ResolveHops:        resolveHops,
+		ArchiveFallback:    archiveMode,
+		RedirectStatus:     redirectCode,
+		PermanentMaxAge:    permanentAge,
+		CreatorGracePeriod: creatorGrace,
+	}
+	if c.RateLimiter, err = newRateLimiter(); err != nil {
+		log.Fatalf("Configuring rate limiter: %v", err)
+	}
+	if c.TrustedProxies, err = parseNetworks(proxies); err != nil {
+		log.Fatalf("Parsing trusted proxies: %v", err)
+	}
+	keys, err := loadCookieKeys(cookieKeys)
+	if err != nil {
+		log.Fatalf("Loading cookie keys: %v", err)
+	}
+	if c.Cookies, err = shorturl.NewCookieCodec(keys, cookieEncrypt); err != nil {
+		log.Fatalf("Loading cookie keys: %v", err)
+	}
+	if blocklists != "" {
+		if c.Blocklist, err = shorturl.LoadBlocklist(strings.Split(blocklists, ",")...); err != nil {
+			log.Fatalf("Loading blocklist: %v", err)
+		}
+		go c.Blocklist.Refresh(blocklistPoll)
+	}
+
+	if snapshotFile != "" {
+		if c.Snapshot, err = shorturl.LoadSnapshot(snapshotFile); err != nil {
+			log.Fatalf("Loading snapshot: %v", err)
+		}
+		go c.Snapshot.Refresh(db, snapshotPoll)
+	}
+	if metricsAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(metricsAddr, expvar.Handler()))
+		}()
+	}
+	if linkCheck > 0 {
+		checker := &shorturl.LinkChecker{
+			Store:       db,
+			Concurrency: linkCheckConc,
+			DomainDelay: linkCheckWait,
+			Interval:    linkCheck,
+		}
+		go checker.Run(time.Hour)
 	}
 
 	log.Print("Listening on http://", listenAddr)
 
-	h := shorturl.Handler(db, secure)
-	if err := http.ListenAndServe(listenAddr, h); err != nil {
+	srv := &http.Server{Addr: listenAddr, Handler: shorturl.Handler(store, c)}
+	stopped := make(chan struct{})
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		<-stop
+		log.Print("Stopping server")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Stopping server: %v", err)
+		}
+		close(stopped)
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-stopped
+	if db != nil {
+		// Hits are collected in memory for a while before they are
+		// counted.
+		if err := db.Flush(); err != nil {
+			log.Printf("Counting hits: %v", err)
+		}
+	}
+}
+
+// checkFrozenFlags checks that no features needing the database are enabled
+// when serving short urls from an export file.
+func checkFrozenFlags() error {
+	switch {
+	case flag.NArg() > 0:
+		return fmt.Errorf("command %s needs the database, not -frozen", flag.Arg(0))
+	case allowCreate:
+		return errors.New("-allow-create cannot be used with -frozen")
+	case rateLimit == "db":
+		return errors.New("-ratelimit=db cannot be used with -frozen")
+	case linkCheck > 0:
+		return errors.New("-linkcheck cannot be used with -frozen")
+	case snapshotFile != "":
+		return errors.New("-snapshot cannot be used with -frozen")
+	case archiveImport != "" || deadLinks:
+		return errors.New("-import-archive and -dead-links cannot be used with -frozen")
+	}
+	return nil
+}
+
+// export runs the export command, writing all short urls to the file for
+// serving them with -frozen.
+func export(path string) error {
+	if path == "" {
+		return fmt.Errorf("usage: export file")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := shorturl.ExportSnapshot(context.Background(), db, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// migrate runs the migrate command: "up" applies pending migrations, "down"
+// reverts the latest one and "status" lists them.
+func migrate(command string) error {
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, m := range applied {
+			log.Printf("Applied migration %d %s", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Print("Database schema is up to date")
+		}
+		return err
+	case "down":
+		m, err := db.MigrateDown(migrateForce)
+		if err == nil && m != nil {
+			log.Printf("Reverted migration %d %s", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		list, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, m := range list {
+			applied := "pending"
+			if m.Applied != nil {
+				applied = m.Applied.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-20s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, want up, down or status", command)
+	}
+}
+
+// importArchive stores the archive URLs listed in the file.
+func importArchive(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	list, err := shorturl.ParseArchiveList(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, s := range list {
+		if err := db.SetArchiveURL(s); err != nil {
+			return fmt.Errorf("%s: %v", s.UID(), err)
+		}
+	}
+	log.Printf("Imported %d archive URLs", len(list))
+	return nil
+}
+
+func newRateLimiter() (*shorturl.RateLimiter, error) {
+	rl := &shorturl.RateLimiter{Limits: rateLimits}
+	switch rateLimit {
+	case "off":
+		return nil, nil
+	case "memory":
+		rl.Backend = shorturl.NewMemoryRateLimitBackend()
+	case "db":
+		rl.Backend = db
+	default:
+		return nil, fmt.Errorf("unknown rate limiter backend %q", rateLimit)
+	}
+	return rl, nil
+}
+
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		if s == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// loadCookieKeys reads the cookie keys from file. If the file does not exist,
+// it is created with a new random key.
+func loadCookieKeys(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		key := shorturl.RandomCookieKey()
+		log.Printf("Creating new cookie key file %s", path)
+		return [][]byte{key}, ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
 }
@@ -0,0 +1,148 @@
+package shorturl
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Cookie codec errors
+var (
+	ErrInvalidCookie = errors.New("invalid cookie value")
+	ErrExpiredCookie = errors.New("expired cookie value")
+)
+
+// minKeyLength is the minimum length of cookie keys in bytes.
+const minKeyLength = 32
+
+// CookieCodec signs, and optionally encrypts, cookie values so that they
+// cannot be forged or altered by the client.
+//
+// New values are always encoded with the first key, and values encoded with
+// any of the keys are accepted. To rotate keys, add a new key first and
+// remove the oldest key once cookies encoded with it have expired.
+type CookieCodec struct {
+	keys    []codecKey
+	encrypt bool
+}
+
+type codecKey struct {
+	sign    []byte
+	encrypt cipher.AEAD
+}
+
+// NewCookieCodec creates a codec from the keys, newest first. If encrypt is
+// set, the values are also encrypted so that the client cannot read them.
+func NewCookieCodec(keys [][]byte, encrypt bool) (*CookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no cookie keys")
+	}
+	c := &CookieCodec{encrypt: encrypt}
+	for _, key := range keys {
+		if len(key) < minKeyLength {
+			return nil, errors.New("cookie key is too short")
+		}
+		// Separate keys for signing and encryption are derived from
+		// the configured key.
+		block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, codecKey{sign: deriveKey(key, "sign"), encrypt: aead})
+	}
+	return c, nil
+}
+
+// RandomCookieKey returns a new random key for NewCookieCodec.
+func RandomCookieKey() []byte {
+	key := make([]byte, minKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("shorturl cookie " + purpose))
+	return mac.Sum(nil)
+}
+
+// Encode returns the encoded value for the cookie of the given name. The
+// name is part of the signature, so a value cannot be moved from one
+// cookie to another.
+func (c *CookieCodec) Encode(name, value string) (string, error) {
+	key := c.keys[0]
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	payload = append(payload, value...)
+	if c.encrypt {
+		nonce := make([]byte, key.encrypt.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = key.encrypt.Seal(nonce, nonce, payload, []byte(name))
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(key.sign, name, payload)), nil
+}
+
+// Decode verifies and returns the value of the cookie of the given name.
+// Values encoded longer than maxAge ago are rejected.
+func (c *CookieCodec) Decode(name, encoded string, maxAge time.Duration) (string, error) {
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, key := range c.keys {
+		if !hmac.Equal(mac, sign(key.sign, name, payload)) {
+			continue
+		}
+		if c.encrypt {
+			size := key.encrypt.NonceSize()
+			if len(payload) < size {
+				return "", ErrInvalidCookie
+			}
+			payload, err = key.encrypt.Open(nil, payload[:size], payload[size:], []byte(name))
+			if err != nil {
+				return "", ErrInvalidCookie
+			}
+		}
+		if len(payload) < 8 {
+			return "", ErrInvalidCookie
+		}
+		created := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+		if time.Since(created) > maxAge {
+			return "", ErrExpiredCookie
+		}
+		return string(payload[8:]), nil
+	}
+	return "", ErrInvalidCookie
+}
+
+func sign(key []byte, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
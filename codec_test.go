@@ -0,0 +1,57 @@
+package shorturl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieCodec(t *testing.T) {
+	oldKey, newKey := RandomCookieKey(), RandomCookieKey()
+	for _, encrypt := range []bool{false, true} {
+		old, err := NewCookieCodec([][]byte{oldKey}, encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rotated, err := NewCookieCodec([][]byte{newKey, oldKey}, encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := old.Encode("prefs", "preview=true")
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(strings.Split(encoded, ".")[0])
+		if readable := bytes.Contains(payload, []byte("preview=true")); readable == encrypt {
+			t.Errorf("value readable = %v with encrypt=%v", readable, encrypt)
+		}
+		// Values encoded with an older key are still accepted.
+		if v, err := rotated.Decode("prefs", encoded, time.Hour); err != nil || v != "preview=true" {
+			t.Errorf("Decode() = %q, %v after key rotation (encrypt=%v)", v, err, encrypt)
+		}
+		if _, err := rotated.Decode("creator", encoded, time.Hour); err != ErrInvalidCookie {
+			t.Errorf("value accepted for another cookie name (encrypt=%v): %v", encrypt, err)
+		}
+		tampered := []byte(encoded)
+		tampered[3] ^= 1
+		if _, err := rotated.Decode("prefs", string(tampered), time.Hour); err != ErrInvalidCookie {
+			t.Errorf("tampered value accepted (encrypt=%v): %v", encrypt, err)
+		}
+		if _, err := rotated.Decode("prefs", encoded, -time.Second); err != ErrExpiredCookie {
+			t.Errorf("expired value accepted (encrypt=%v): %v", encrypt, err)
+		}
+		// Values encoded with the new key are not accepted before rotation.
+		encoded, _ = rotated.Encode("prefs", "preview=true")
+		if _, err := old.Decode("prefs", encoded, time.Hour); err != ErrInvalidCookie {
+			t.Errorf("value with unknown key accepted (encrypt=%v): %v", encrypt, err)
+		}
+	}
+}
+
+func TestCookieCodecShortKey(t *testing.T) {
+	if _, err := NewCookieCodec([][]byte{[]byte("secret")}, false); err == nil {
+		t.Error("short key was accepted")
+	}
+}
@@ -1,21 +1,173 @@
 package shorturl
 
-import "net/http"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
 
-// Cookie names used by shorturl service.
+// Cookie names used by shorturl service. The values are encoded with the
+// CookieCodec of the service.
 const (
-	// alwaysPreviewCookie is the name of cookie for preference to
-	// always show a preview page instead of immediately redirecting to
-	// target. The value is "true" when enabled, otherwise ignored.
-	alwaysPreviewCookie = "preview"
+	// prefsCookie is the name of cookie holding the user preferences as
+	// URL encoded form values. "preview" is "true" to always show a
+	// preview page instead of immediately redirecting to target, and "tz"
+	// is the IANA name of the time zone for times shown on pages, such as
+	// "Europe/Helsinki". "lang" is the language of the pages, e.g. "fi".
+	prefsCookie = "prefs"
+	// creatorCookie is the name of cookie holding the anonymous identity
+	// of a browser that has created short urls. The value is a random id,
+	// stored with each short url created.
+	creatorCookie = "creator"
+	// legacyPreviewCookie is the name of the unsigned cookie that held
+	// the always preview preference as "true" before preferences were
+	// signed. It is replaced with prefsCookie when seen.
+	legacyPreviewCookie = "preview"
 )
 
-func alwaysPreviewPref(req *http.Request) bool {
-	cookies := req.Cookies()
-	for _, cookie := range cookies {
-		if cookie.Name == alwaysPreviewCookie && cookie.Value == "true" {
-			return true
+// Cookie lifetimes in seconds
+const (
+	prefsMaxAge   = 365 * 24 * 60 * 60
+	creatorMaxAge = 5 * 365 * 24 * 60 * 60
+)
+
+// timeZones are the time zones offered on the preferences page.
+var timeZones = []string{
+	"UTC",
+	"Europe/Helsinki",
+	"Europe/Stockholm",
+	"Europe/Tallinn",
+	"Europe/Berlin",
+	"Europe/London",
+	"America/New_York",
+	"America/Los_Angeles",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+}
+
+// Prefs are the user preferences stored in cookies.
+type Prefs struct {
+	// AlwaysPreview shows the preview page instead of redirecting.
+	AlwaysPreview bool
+	// TimeZone is the time zone for showing times, or empty for the
+	// server default.
+	TimeZone string
+	// Language is the language of the pages, or empty to use the
+	// languages accepted by the browser.
+	Language string
+}
+
+type prefsKey struct{}
+
+// withPrefs decodes the preferences cookie for the handlers below it.
+func withPrefs(c Config, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var p Prefs
+		v := c.readCookie(req, prefsCookie, prefsMaxAge)
+		if v != "" {
+			values, _ := url.ParseQuery(v)
+			p.AlwaysPreview = values.Get("preview") == "true"
+			p.TimeZone = values.Get("tz")
+			p.Language = values.Get("lang")
+		}
+		if legacy, err := req.Cookie(legacyPreviewCookie); err == nil {
+			if v == "" && legacy.Value == "true" {
+				p.AlwaysPreview = true
+				if err := c.savePrefs(w, p); err != nil {
+					log.Printf("saving preferences: %v", err)
+				}
+			}
+			http.SetCookie(w, &http.Cookie{Name: legacyPreviewCookie, Path: "/", MaxAge: -1})
 		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), prefsKey{}, p)))
+	})
+}
+
+func readPrefs(req *http.Request) Prefs {
+	p, _ := req.Context().Value(prefsKey{}).(Prefs)
+	return p
+}
+
+func alwaysPreviewPref(req *http.Request) bool {
+	return readPrefs(req).AlwaysPreview
+}
+
+// Location is the preferred time zone, or nil if it is not set or not
+// known.
+func (p Prefs) Location() *time.Location {
+	if p.TimeZone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// savePrefs stores the preferences in the browser.
+func (c Config) savePrefs(w http.ResponseWriter, p Prefs) error {
+	values := url.Values{}
+	if p.AlwaysPreview {
+		values.Set("preview", "true")
+	}
+	if p.TimeZone != "" {
+		values.Set("tz", p.TimeZone)
+	}
+	if p.Language != "" {
+		values.Set("lang", p.Language)
+	}
+	return c.writeCookie(w, prefsCookie, values.Encode(), prefsMaxAge)
+}
+
+// creator returns the anonymous identity of the browser, creating one if
+// the browser does not have it yet.
+func (c Config) creator(w http.ResponseWriter, req *http.Request) (string, error) {
+	if id := c.readCookie(req, creatorCookie, creatorMaxAge); id != "" {
+		return id, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	return id, c.writeCookie(w, creatorCookie, id, creatorMaxAge)
+}
+
+// readCookie returns the decoded value of the cookie, or empty string if the
+// cookie is not set or its value is not valid.
+func (c Config) readCookie(req *http.Request, name string, maxAge int) string {
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	v, err := c.Cookies.Decode(name, cookie.Value, time.Duration(maxAge)*time.Second)
+	if err != nil {
+		return ""
+	}
+	return v
+}
+
+// writeCookie sets the cookie with encoded value. The cookies are sent on
+// top-level navigation from other sites, as that is when the always preview
+// preference is needed.
+func (c Config) writeCookie(w http.ResponseWriter, name, value string, maxAge int) error {
+	encoded, err := c.Cookies.Encode(name, value)
+	if err != nil {
+		return err
 	}
-	return false
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
 }
@@ -0,0 +1,120 @@
+package shorturl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testConfig(t *testing.T) Config {
+	codec, err := NewCookieCodec([][]byte{RandomCookieKey()}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{Cookies: codec}
+}
+
+func TestPrefsHandler(t *testing.T) {
+	c := testConfig(t)
+	c.Secure = true
+	h := withPrefs(c, prefsHandler(c))
+	form := url.Values{"preview": {"true"}, "tz": {"UTC"}}
+	req := httptest.NewRequest("POST", "http://yx.fi/prefs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "http://yx.fi/prefs")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /prefs status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	for _, cookie := range cookies {
+		if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+			t.Errorf("cookie %s attributes: %+v", cookie.Name, cookie)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "http://yx.fi/prefs?saved=1", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "saved") || !strings.Contains(body, "checked") ||
+		!strings.Contains(body, `<option value="UTC" selected>`) {
+		t.Errorf("saved preferences not shown on page:\n%s", body)
+	}
+}
+
+func TestTamperedPrefsIgnored(t *testing.T) {
+	c := testConfig(t)
+	var got Prefs
+	h := withPrefs(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = readPrefs(req)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: prefsCookie, Value: "preview=true"})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.AlwaysPreview {
+		t.Error("unsigned preferences cookie was accepted")
+	}
+}
+
+func TestLegacyPreviewCookie(t *testing.T) {
+	c := testConfig(t)
+	var got Prefs
+	h := withPrefs(c, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = readPrefs(req)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: legacyPreviewCookie, Value: "true"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !got.AlwaysPreview {
+		t.Error("legacy preview cookie was not accepted")
+	}
+
+	// The legacy cookie is expired and the signed one is read from now on.
+	req = httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == legacyPreviewCookie {
+			if cookie.MaxAge >= 0 {
+				t.Errorf("legacy cookie not expired: %+v", cookie)
+			}
+			continue
+		}
+		req.AddCookie(cookie)
+	}
+	got = Prefs{}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !got.AlwaysPreview {
+		t.Error("legacy preview preference was not saved in the signed cookie")
+	}
+}
+
+var prefsRejectTests = []struct {
+	referer string
+	tz      string
+}{
+	{"http://evil.example/", ""},
+	{"http://yx.fi/prefs", "Nowhere/Nothing"},
+}
+
+func TestPrefsHandlerRejects(t *testing.T) {
+	for _, c := range prefsRejectTests {
+		form := url.Values{"tz": {c.tz}}
+		req := httptest.NewRequest("POST", "http://yx.fi/prefs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", c.referer)
+		w := httptest.NewRecorder()
+		prefsHandler(testConfig(t)).ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || len(w.Result().Cookies()) != 0 {
+			t.Errorf("POST from %s with tz %q: status %d, cookies %v", c.referer, c.tz, w.Code, w.Result().Cookies())
+		}
+	}
+}
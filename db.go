@@ -1,20 +1,101 @@
 package shorturl
 
 import (
+	"context"
 	"database/sql"
-	"strconv"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/lib/pq" // postgresql driver
+	"github.com/lib/pq"
 )
 
+// DB is the database of short urls. Writes go to the primary database, and
+// reads for redirects and previews to read replicas, if there are any.
 type DB struct {
+	*pool
+	// QueryTimeout limits the time of each query made with a context.
+	// Zero means no limit.
+	QueryTimeout time.Duration
+	// HitDelay is how long hits are collected before they are counted in
+	// the database together. Zero uses defaultHitDelay.
+	HitDelay time.Duration
+
+	replicas []*pool
+	next     uint32
+
+	hitsMu    sync.Mutex
+	hits      map[int64]int64
+	hitsTimer *time.Timer
+
+	pruneMu sync.Mutex
+	pruned  map[string]time.Time
+}
+
+// defaultHitDelay is the default of DB.HitDelay.
+const defaultHitDelay = 10 * time.Second
+
+// pool is a database connection pool and its prepared statements.
+type pool struct {
 	*sql.DB
+
+	mu     sync.Mutex
+	stmts  map[string]*sql.Stmt
+	failed time.Time
 }
 
+// ErrTimeout is returned when a query takes longer than QueryTimeout.
+var ErrTimeout = errors.New("database query timed out")
+
 // SQL
 const (
-	sqlByID  = "SELECT url, host, ts FROM shorturl WHERE id = $1"
-	sqlByURL = "SELECT id, host, ts FROM shorturl WHERE url = $1"
+	// sqlShorturlColumns are the columns read by scanShorturl.
+	sqlShorturlColumns = `id, url, host, ts, COALESCE(final_url, ''), COALESCE(passthrough, ''),
+		COALESCE(redirect_status, 0), hits, disabled,
+		check_status, COALESCE(check_error, ''), check_redirects, checked, check_failures,
+		dead, COALESCE(archive_url, '')`
+	sqlShorturl = "SELECT " + sqlShorturlColumns + " FROM shorturl"
+	sqlByID     = sqlShorturl + " WHERE id = $1"
+	sqlByIDs    = sqlShorturl + " WHERE id = ANY($1)"
+	sqlExport   = sqlShorturl + " WHERE id > $1 ORDER BY id LIMIT $2"
+	// sqlInsert returns the existing short url on conflict. The update
+	// marks it shared if it was added by another creator, and makes the
+	// conflicting row available to RETURNING.
+	sqlInsert = `INSERT INTO shorturl (url, canonical, host, cookie, passthrough, redirect_status, final_url)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), NULLIF($7, ''))
+		ON CONFLICT (canonical) DO UPDATE
+		SET shared = shorturl.shared OR shorturl.cookie IS DISTINCT FROM EXCLUDED.cookie
+		RETURNING id, url, host, ts`
+	sqlHits = `UPDATE shorturl SET hits = hits + h.n
+		FROM unnest($1::integer[], $2::bigint[]) AS h(id, n) WHERE shorturl.id = h.id`
+	sqlByCreator = "SELECT " + sqlShorturlColumns + `, ts > now() - $2 * interval '1 second' AND NOT shared
+		FROM shorturl WHERE cookie = $1 ORDER BY id DESC`
+	sqlDelete = `DELETE FROM shorturl
+		WHERE id = $1 AND cookie = $2 AND ts > now() - $3 * interval '1 second' AND NOT shared`
+	sqlSetArchive = "UPDATE shorturl SET archive_url = NULLIF($2, '') WHERE id = $1"
+	// sqlSetDisabled clears the canonical url of disabled short urls, so
+	// that they are not found as duplicates.
+	sqlSetDisabled = `UPDATE shorturl SET disabled = $4, canonical = CASE WHEN $4 THEN NULL ELSE canonical END
+		WHERE id = $1 AND cookie = $2 AND ts > now() - $3 * interval '1 second' AND NOT shared`
+	sqlToCheck = `SELECT id, url, host, ts, hits FROM shorturl
+		WHERE NOT disabled AND url ~* '^https?://'
+			AND (checked IS NULL OR checked < $1 OR (check_failures > 0 AND checked < $3))
+		ORDER BY checked NULLS FIRST, id LIMIT $2`
+	sqlSaveCheck = `UPDATE shorturl SET check_status = NULLIF($2, 0), check_error = NULLIF($3, ''),
+		check_redirects = $4, checked = $5,
+		check_failures = CASE WHEN $6::boolean THEN check_failures + 1 ELSE 0 END
+		WHERE id = $1 RETURNING check_failures`
+	sqlChecked = `SELECT id, url, host, ts, hits,
+		check_status, COALESCE(check_error, ''), check_redirects, checked, check_failures
+		FROM shorturl WHERE checked IS NOT NULL ORDER BY id`
+
+	sqlRateLimitGet = "SELECT tokens, updated FROM ratelimit WHERE key = $1 FOR UPDATE"
+	sqlRateLimitPut = `INSERT INTO ratelimit (key, tokens, updated) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET tokens = $2, updated = $3`
+	sqlRateLimitPrune = `DELETE FROM ratelimit WHERE left(key, length($1)) = $1
+		AND tokens + extract(epoch FROM now() - updated) * $2 >= $3`
 )
 
 // Open creates a database configured from command line flags.
@@ -23,20 +104,501 @@ func Open(dataSourceName string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{pool: &pool{DB: db}}, nil
+}
+
+// context returns the context for a query, limited to QueryTimeout.
+func (db *DB) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.QueryTimeout)
+}
+
+// queryError returns ErrTimeout if the query failed because its context
+// timed out.
+func queryError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
+
+// stmt returns the query as a prepared statement. Statements are prepared
+// on first use and kept for the lifetime of the pool.
+func (p *pool) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	p.mu.Lock()
+	stmt, ok := p.stmts[query]
+	p.mu.Unlock()
+	if ok {
+		return stmt, nil
+	}
+	stmt, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if prepared, ok := p.stmts[query]; ok {
+		// Prepared at the same time by another query.
+		stmt.Close()
+		return prepared, nil
+	}
+	if p.stmts == nil {
+		p.stmts = make(map[string]*sql.Stmt)
+	}
+	p.stmts[query] = stmt
+	return stmt, nil
 }
 
 // Get retrieves short url from database by short id
-func (db *DB) Get(shortCode string) (*Shorturl, error) {
-	var err error
-	id, err := strconv.ParseInt(shortCode, idBase, 32)
+func (db *DB) Get(ctx context.Context, shortCode string) (*Shorturl, error) {
+	id, err := parseUID(shortCode)
 	if err != nil {
 		return nil, ErrNotFound
 	}
-	s := &Shorturl{ID: id}
-	err = db.QueryRow(sqlByID, s.ID).Scan(&s.URL, &s.Host, &s.Added)
+	var s *Shorturl
+	err = db.read(ctx, func(ctx context.Context, p *pool) error {
+		stmt, err := p.stmt(ctx, sqlByID)
+		if err != nil {
+			return err
+		}
+		s, err = scanShorturl(stmt.QueryRowContext(ctx, id))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetMany retrieves the short urls of the short codes in one query. The
+// result is in the order of codes, with nil for codes that are not found.
+func (db *DB) GetMany(ctx context.Context, codes []string) ([]*Shorturl, error) {
+	var ids []int64
+	for _, code := range codes {
+		if id, err := parseUID(code); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	byID, err := db.byIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Shorturl, len(codes))
+	for i, code := range codes {
+		if id, err := parseUID(code); err == nil {
+			list[i] = byID[id]
+		}
+	}
+	return list, nil
+}
+
+// byIDs retrieves the short urls by id in one query.
+func (db *DB) byIDs(ctx context.Context, ids []int64) (map[int64]*Shorturl, error) {
+	byID := make(map[int64]*Shorturl, len(ids))
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	err := db.read(ctx, func(ctx context.Context, p *pool) error {
+		stmt, err := p.stmt(ctx, sqlByIDs)
+		if err != nil {
+			return err
+		}
+		rows, err := stmt.QueryContext(ctx, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			s, err := scanShorturl(rows)
+			if err != nil {
+				return err
+			}
+			byID[s.ID] = s
+		}
+		return rows.Err()
+	})
+	return byID, err
+}
+
+// exportBatch is the number of short urls read at a time for Export.
+const exportBatch = 1000
+
+// Export calls fn for each short url in id order. The export is read from
+// the primary database in batches, so that no query runs for long even
+// though the whole table is read.
+func (db *DB) Export(ctx context.Context, fn func(*Shorturl) error) error {
+	var last int64
+	for {
+		list, err := db.exportAfter(ctx, last)
+		if err != nil || len(list) == 0 {
+			return err
+		}
+		for _, s := range list {
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+		last = list[len(list)-1].ID
+	}
+}
+
+// exportAfter returns the next exportBatch short urls with ids greater than
+// id, in order.
+func (db *DB) exportAfter(ctx context.Context, id int64) ([]*Shorturl, error) {
+	ctx, cancel := db.context(ctx)
+	defer cancel()
+	stmt, err := db.stmt(ctx, sqlExport)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	rows, err := stmt.QueryContext(ctx, id, exportBatch)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	var list []*Shorturl
+	for rows.Next() {
+		s, err := scanShorturl(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		list = append(list, s)
+	}
+	return list, queryError(ctx, rows.Err())
+}
+
+// scanShorturl reads a short url selected with sqlShorturlColumns, and the
+// extra columns selected after them.
+func scanShorturl(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Shorturl, error) {
+	s := &Shorturl{}
+	var check checkColumns
+	dest := []interface{}{&s.ID, &s.URL, &s.Host, &s.Added, &s.FinalURL, &s.Passthrough, &s.RedirectStatus, &s.Hits, &s.Disabled,
+		&check.status, &check.err, &check.redirects, &check.checked, &check.failures, &s.MarkedDead, &s.ArchiveURL}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	s.Check = check.linkCheck()
+	return s, nil
+}
+
+// Add stores a new short url. If a short url with the same canonical URL
+// already exists and is not disabled, s is filled in from it instead. If
+// it was added by another creator, it becomes shared. The unique index on the
+// canonical url keeps concurrent adds of the same URL from both inserting.
+func (db *DB) Add(ctx context.Context, s *Shorturl) error {
+	if s.Canonical == "" {
+		s.Canonical = s.URL
+	}
+	ctx, cancel := db.context(ctx)
+	defer cancel()
+	insert, err := db.stmt(ctx, sqlInsert)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	err = insert.QueryRowContext(ctx, s.URL, s.Canonical, s.Host, s.Creator, s.Passthrough, s.RedirectStatus, s.FinalURL).
+		Scan(&s.ID, &s.URL, &s.Host, &s.Added)
+	return queryError(ctx, err)
+}
+
+// Hit counts a redirect through the short url. Hits are collected in
+// memory and counted in the database in batches after HitDelay, so that
+// redirects do not wait for a write. The context is not used, as the hit
+// is written after the request. Call Flush before exiting to count the
+// collected hits.
+func (db *DB) Hit(_ context.Context, s *Shorturl) error {
+	db.addHits(map[int64]int64{s.ID: 1})
+	return nil
+}
+
+// addHits adds to the hits waiting to be counted, scheduling a write if
+// there were none.
+func (db *DB) addHits(hits map[int64]int64) {
+	db.hitsMu.Lock()
+	defer db.hitsMu.Unlock()
+	if db.hits == nil {
+		db.hits = make(map[int64]int64)
+		delay := db.HitDelay
+		if delay <= 0 {
+			delay = defaultHitDelay
+		}
+		db.hitsTimer = time.AfterFunc(delay, db.writeHits)
+	}
+	for id, n := range hits {
+		db.hits[id] += n
+	}
+}
+
+// takeHits returns the collected hits and stops the scheduled write.
+func (db *DB) takeHits() map[int64]int64 {
+	db.hitsMu.Lock()
+	defer db.hitsMu.Unlock()
+	hits := db.hits
+	if db.hitsTimer != nil {
+		db.hitsTimer.Stop()
+	}
+	db.hits, db.hitsTimer = nil, nil
+	return hits
+}
+
+// writeHits counts the collected hits in the database. If that fails, they
+// are kept for the next batch.
+func (db *DB) writeHits() {
+	hits := db.takeHits()
+	if err := db.countHits(hits); err != nil {
+		log.Printf("counting hits: %v", err)
+		db.addHits(hits)
+	}
+}
+
+// Flush counts the collected hits in the database now, so that they are
+// not lost when the server stops.
+func (db *DB) Flush() error {
+	return db.countHits(db.takeHits())
+}
+
+// countHits adds the hits to the hit counts of the short urls.
+func (db *DB) countHits(hits map[int64]int64) error {
+	if len(hits) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(hits))
+	counts := make([]int64, 0, len(hits))
+	for id, n := range hits {
+		ids = append(ids, id)
+		counts = append(counts, n)
+	}
+	ctx, cancel := db.context(context.Background())
+	defer cancel()
+	stmt, err := db.stmt(ctx, sqlHits)
+	if err == nil {
+		_, err = stmt.ExecContext(ctx, pq.Array(ids), pq.Array(counts))
+	}
+	return queryError(ctx, err)
+}
+
+// ByCreator lists the short urls added by creator, newest first. Editable is
+// set for the short urls added within the grace period that have not been
+// shared. The short urls are
+// read from the primary database, which has the changes the creator just
+// made.
+func (db *DB) ByCreator(ctx context.Context, creator string, grace time.Duration) ([]CreatedShorturl, error) {
+	ctx, cancel := db.context(ctx)
+	defer cancel()
+	stmt, err := db.stmt(ctx, sqlByCreator)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	rows, err := stmt.QueryContext(ctx, creator, grace.Seconds())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	var list []CreatedShorturl
+	for rows.Next() {
+		var c CreatedShorturl
+		if c.Shorturl, err = scanShorturl(rows, &c.Editable); err != nil {
+			return nil, queryError(ctx, err)
+		}
+		c.Creator = creator
+		list = append(list, c)
+	}
+	return list, queryError(ctx, rows.Err())
+}
+
+// Delete removes a short url added by creator within the grace period.
+// Short urls that have been shared cannot be deleted or disabled, as the
+// others who got them may already have passed them on.
+func (db *DB) Delete(ctx context.Context, s *Shorturl, grace time.Duration) error {
+	return db.execByCreator(ctx, sqlDelete, s.ID, s.Creator, grace.Seconds())
+}
+
+// SetDisabled disables or enables a short url added by creator within the
+// grace period. A disabled short url is no longer used for its URL, so the
+// URL can be shortened again. It stays that way if the short url is enabled
+// again.
+func (db *DB) SetDisabled(ctx context.Context, s *Shorturl, grace time.Duration) error {
+	return db.execByCreator(ctx, sqlSetDisabled, s.ID, s.Creator, grace.Seconds(), s.Disabled)
+}
+
+// SetArchiveURL stores the archive URL of the short url.
+func (db *DB) SetArchiveURL(s *Shorturl) error {
+	res, err := db.Exec(sqlSetArchive, s.ID, s.ArchiveURL)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// execByCreator executes a statement changing one short url, returning
+// ErrNotFound if nothing was changed.
+func (db *DB) execByCreator(ctx context.Context, query string, args ...interface{}) error {
+	ctx, cancel := db.context(ctx)
+	defer cancel()
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LinksToCheck implements LinkCheckStore. Links that have never been
+// checked come first.
+func (db *DB) LinksToCheck(before time.Time, limit int) ([]*Shorturl, error) {
+	ctx, cancel := db.context(context.Background())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, sqlToCheck, before, limit, time.Now().Add(-linkCheckRetry))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	var list []*Shorturl
+	for rows.Next() {
+		s := &Shorturl{}
+		if err := rows.Scan(&s.ID, &s.URL, &s.Host, &s.Added, &s.Hits); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// SaveCheck implements LinkCheckStore.
+func (db *DB) SaveCheck(s *Shorturl) error {
+	ctx, cancel := db.context(context.Background())
+	defer cancel()
+	c := s.Check
+	err := db.QueryRowContext(ctx, sqlSaveCheck, s.ID, c.Status, c.Error, pq.Array(c.Redirects), c.Checked, c.Failed()).
+		Scan(&c.Failures)
 	if err == sql.ErrNoRows {
-		return nil, ErrNotFound
+		// Deleted while being checked.
+		return nil
+	}
+	return queryError(ctx, err)
+}
+
+// CheckedLinks lists the short urls that have been checked with their latest
+// check, for the dead link report.
+func (db *DB) CheckedLinks() ([]*Shorturl, error) {
+	rows, err := db.Query(sqlChecked)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*Shorturl
+	for rows.Next() {
+		s := &Shorturl{}
+		var check checkColumns
+		err := rows.Scan(&s.ID, &s.URL, &s.Host, &s.Added, &s.Hits,
+			&check.status, &check.err, &check.redirects, &check.checked, &check.failures)
+		if err != nil {
+			return nil, err
+		}
+		s.Check = check.linkCheck()
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// checkColumns are the nullable link check columns of a short url.
+type checkColumns struct {
+	status    sql.NullInt64
+	err       string
+	redirects pq.StringArray
+	checked   pq.NullTime
+	failures  int
+}
+
+// linkCheck returns the check, or nil if the link has not been checked.
+func (c checkColumns) linkCheck() *LinkCheck {
+	if !c.checked.Valid {
+		return nil
+	}
+	return &LinkCheck{
+		Status:    int(c.status.Int64),
+		Error:     c.err,
+		Redirects: c.redirects,
+		Checked:   c.checked.Time,
+		Failures:  c.failures,
+	}
+}
+
+// Take implements RateLimitBackend, sharing the token buckets between all
+// server processes using the same database.
+func (db *DB) Take(ctx context.Context, key string, l Limit) (bool, time.Duration, error) {
+	ctx, cancel := db.context(ctx)
+	defer cancel()
+	get, err := db.stmt(ctx, sqlRateLimitGet)
+	if err != nil {
+		return false, 0, queryError(ctx, err)
+	}
+	put, err := db.stmt(ctx, sqlRateLimitPut)
+	if err != nil {
+		return false, 0, queryError(ctx, err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	var b bucket
+	err = tx.StmtContext(ctx, get).QueryRowContext(ctx, key).Scan(&b.tokens, &b.updated)
+	if err != nil && err != sql.ErrNoRows {
+		return false, 0, queryError(ctx, err)
+	}
+	ok, retryAfter := b.take(l, time.Now())
+	if _, err := tx.StmtContext(ctx, put).ExecContext(ctx, key, b.tokens, b.updated); err != nil {
+		return false, 0, queryError(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, 0, queryError(ctx, err)
+	}
+	if err := db.pruneRateLimit(ctx, key, l); err != nil {
+		log.Printf("pruning rate limit buckets: %v", queryError(ctx, err))
+	}
+	return ok, retryAfter, nil
+}
+
+// pruneRateLimit deletes the buckets that have refilled completely, as
+// forgetting them makes no difference. Buckets of a class share its limit,
+// so the buckets deleted are those of the class of key, the part before
+// the first colon. Each class is pruned at most once per
+// rateLimitPruneInterval.
+func (db *DB) pruneRateLimit(ctx context.Context, key string, l Limit) error {
+	class := key
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		class = key[:i+1]
+	}
+	now := time.Now()
+	db.pruneMu.Lock()
+	if now.Sub(db.pruned[class]) < rateLimitPruneInterval {
+		db.pruneMu.Unlock()
+		return nil
+	}
+	if db.pruned == nil {
+		db.pruned = make(map[string]time.Time)
+	}
+	db.pruned[class] = now
+	db.pruneMu.Unlock()
+	stmt, err := db.stmt(ctx, sqlRateLimitPrune)
+	if err != nil {
+		return err
 	}
-	return s, err
+	_, err = stmt.ExecContext(ctx, class, l.Rate, l.Burst)
+	return err
 }
@@ -0,0 +1,233 @@
+package shorturl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryError(t *testing.T) {
+	db := &DB{QueryTimeout: time.Millisecond}
+	ctx, cancel := db.context(context.Background())
+	defer cancel()
+	<-ctx.Done()
+	if err := queryError(ctx, errors.New("pq: canceling statement due to user request")); err != ErrTimeout {
+		t.Errorf("queryError after timeout = %v, want ErrTimeout", err)
+	}
+	if err := queryError(context.Background(), nil); err != nil {
+		t.Errorf("queryError(nil) = %v", err)
+	}
+
+	// A canceled request is not a timeout.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err := errors.New("canceled")
+	if got := queryError(ctx, err); got != err {
+		t.Errorf("queryError after cancel = %v, want %v", got, err)
+	}
+}
+
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if len(dest) != len(r) {
+		return fmt.Errorf("scanning %d columns into %d values", len(r), len(dest))
+	}
+	for i, v := range r {
+		if s, ok := dest[i].(sql.Scanner); ok {
+			if err := s.Scan(v); err != nil {
+				return err
+			}
+			continue
+		}
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v).Convert(reflect.TypeOf(dest[i]).Elem()))
+	}
+	return nil
+}
+
+func TestScanShorturl(t *testing.T) {
+	added := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, err := scanShorturl(fakeRow{
+		int64(1270), "https://example.com/", "192.0.2.1", added, "", "append",
+		int64(308), int64(42), false,
+		nil, "", nil, nil, int64(0), false, "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UID() != "za" || s.Passthrough != PassthroughAppend || s.RedirectStatus != 308 || s.Hits != 42 {
+		t.Errorf("scanShorturl = %+v", s)
+	}
+	if s.Check != nil {
+		t.Errorf("unchecked short url has check %+v", s.Check)
+	}
+
+	s, err = scanShorturl(fakeRow{
+		int64(1270), "https://example.com/", "192.0.2.1", added, "", "",
+		int64(0), int64(0), false,
+		int64(503), "", []byte("{https://example.com/a}"), added, int64(2), false, "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Check; c == nil || c.Status != 503 || len(c.Redirects) != 1 || !c.Checked.Equal(added) || c.Failures != 2 {
+		t.Errorf("scanShorturl check = %+v", s.Check)
+	}
+}
+
+func TestHitCollected(t *testing.T) {
+	db := &DB{HitDelay: time.Hour}
+	for _, id := range []int64{1, 2, 1} {
+		if err := db.Hit(context.Background(), &Shorturl{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.hits[1] != 2 || db.hits[2] != 1 {
+		t.Errorf("collected hits = %v, want 1:2 2:1", db.hits)
+	}
+}
+
+// testDB connects to the PostgreSQL database in SHORTURL_TEST_DB and
+// migrates it up, or skips the test if it is not set. All short urls are
+// deleted after the test, so it must be a database used only for tests.
+func testDB(t *testing.T) *DB {
+	dataSourceName := os.Getenv("SHORTURL_TEST_DB")
+	if dataSourceName == "" {
+		t.Skip("SHORTURL_TEST_DB is not set")
+	}
+	db, err := Open(dataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("TRUNCATE shorturl"); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAddDisabledAgain(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	first := &Shorturl{URL: "https://example.com/a", Canonical: "https://example.com/a", Creator: "first"}
+	if err := db.Add(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	first.Disabled = true
+	if err := db.SetDisabled(ctx, first, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	again := &Shorturl{URL: first.URL, Canonical: first.Canonical, Creator: "second"}
+	if err := db.Add(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID == first.ID {
+		t.Errorf("adding the URL of disabled short url %s returned it", first.UID())
+	}
+	s, err := db.Get(ctx, again.UID())
+	if err != nil || s.Disabled {
+		t.Errorf("Get(%s) = %+v, %v; want enabled short url", again.UID(), s, err)
+	}
+}
+
+func TestSharedNotEditable(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	first := &Shorturl{URL: "https://example.com/b", Canonical: "https://example.com/b", Creator: "first"}
+	if err := db.Add(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second := &Shorturl{URL: first.URL, Canonical: first.Canonical, Creator: "second"}
+	if err := db.Add(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("second add of the URL got id %d, want %d", second.ID, first.ID)
+	}
+	list, err := db.ByCreator(ctx, "first", time.Hour)
+	if err != nil || len(list) != 1 || list[0].Editable {
+		t.Errorf("ByCreator(first) = %+v, %v; want one shared short url, not editable", list, err)
+	}
+	first.Disabled = true
+	if err := db.SetDisabled(ctx, first, time.Hour); err != ErrNotFound {
+		t.Errorf("SetDisabled of shared short url = %v, want ErrNotFound", err)
+	}
+	if err := db.Delete(ctx, first, time.Hour); err != ErrNotFound {
+		t.Errorf("Delete of shared short url = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSaveCheckFailures(t *testing.T) {
+	db := testDB(t)
+	s := &Shorturl{URL: "https://example.com/c", Canonical: "https://example.com/c"}
+	if err := db.Add(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= linkCheckDeadAfter; i++ {
+		s.Check = &LinkCheck{Status: 503, Checked: time.Now()}
+		if err := db.SaveCheck(s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Check.Failures != i {
+			t.Errorf("failures after %d failed checks = %d", i, s.Check.Failures)
+		}
+	}
+	if !s.Check.Dead() {
+		t.Errorf("target not dead after %d failed checks", linkCheckDeadAfter)
+	}
+	s.Check = &LinkCheck{Status: 200, Checked: time.Now()}
+	if err := db.SaveCheck(s); err != nil || s.Check.Failures != 0 {
+		t.Errorf("failures after working check = %d, %v; want 0", s.Check.Failures, err)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	var added []*Shorturl
+	for _, u := range []string{"https://example.com/d", "https://example.com/e"} {
+		s := &Shorturl{URL: u, Canonical: u}
+		if err := db.Add(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, s)
+	}
+	missing := (&Shorturl{ID: added[1].ID + 1000}).UID()
+	list, err := db.GetMany(ctx, []string{added[1].UID(), missing, added[0].UID(), "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 || list[0] == nil || list[0].ID != added[1].ID || list[1] != nil ||
+		list[2] == nil || list[2].ID != added[0].ID || list[3] != nil {
+		t.Errorf("GetMany = %+v, want short urls %d, nil, %d, nil", list, added[1].ID, added[0].ID)
+	}
+}
+
+func TestFlushHits(t *testing.T) {
+	db := testDB(t)
+	db.HitDelay = time.Hour
+	ctx := context.Background()
+	s := &Shorturl{URL: "https://example.com/f", Canonical: "https://example.com/f"}
+	if err := db.Add(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	db.Hit(ctx, s)
+	db.Hit(ctx, s)
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Get(ctx, s.UID())
+	if err != nil || got.Hits != 2 {
+		t.Errorf("hits after Flush = %d, %v; want 2", got.Hits, err)
+	}
+}
@@ -0,0 +1,248 @@
+package shorturl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// problemJSON is the media type of error responses for API clients, from
+// RFC 7807.
+const problemJSON = "application/problem+json"
+
+// errorPage is the page shown for errors with a status.
+type errorPage struct {
+	// Title and Detail describe the error when the error does not. They
+	// are in English, and translated on the page.
+	Title  string
+	Detail string
+}
+
+// errorPages are the pages of the error statuses. The page is rendered with
+// the template <status>.html if there is one, e.g. 404.html, and otherwise
+// with error.html. To customize a page, add its template with
+// -templates-dir.
+var errorPages = map[int]errorPage{
+	http.StatusBadRequest: {
+		Title:  "Bad request",
+		Detail: "The request was not valid.",
+	},
+	http.StatusForbidden: {
+		Title:  "Forbidden",
+		Detail: "You are not allowed to do that.",
+	},
+	http.StatusNotFound: {
+		Title:  "Page not found",
+		Detail: "The requested page was not found.",
+	},
+	http.StatusGone: {
+		Title:  "Gone",
+		Detail: "The requested page is no longer available.",
+	},
+	http.StatusTooManyRequests: {
+		Title:  "Too many requests",
+		Detail: "You have made too many requests in a short time. Please wait a moment and try again.",
+	},
+	http.StatusUnavailableForLegalReasons: {
+		Title:  "Unavailable for legal reasons",
+		Detail: "This short URL has been removed for legal reasons.",
+	},
+	http.StatusInternalServerError: {
+		Title:  "Internal server error",
+		Detail: "There was an error and we failed to handle it. Sorry.",
+	},
+	http.StatusServiceUnavailable: {
+		Title:  "Service unavailable",
+		Detail: "The service is temporarily overloaded. Please try again in a moment.",
+	},
+}
+
+// Error is an error with the response for it. Handlers return errors, which
+// are mapped to an Error with errorResponse and served.
+type Error struct {
+	// Status is the status code of the response.
+	Status int
+	// Title and Detail describe the error to the user, in English. Empty
+	// uses the texts of the page of the status.
+	Title  string
+	Detail string
+	// RetryAfter tells the client when to try again, if set.
+	RetryAfter time.Duration
+	// Err is the cause. It is logged for server errors, but not shown.
+	Err error
+}
+
+func (e *Error) Error() string {
+	title, _ := e.texts()
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, title, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Status, title)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// texts are the title and detail of the error, defaulting to those of its
+// page.
+func (e *Error) texts() (title, detail string) {
+	page, ok := errorPages[e.Status]
+	if !ok {
+		page.Title = http.StatusText(e.Status)
+	}
+	title, detail = e.Title, e.Detail
+	if title == "" {
+		title = page.Title
+	}
+	if detail == "" {
+		detail = page.Detail
+	}
+	return title, detail
+}
+
+// Errors of the handlers
+var (
+	errorEOL = &Error{
+		Status: http.StatusGone,
+		Title:  "Service is end-of-life",
+		Detail: "This short url service is end of life. Existing redirects continue to work for now.",
+	}
+	errorNotFound = &Error{
+		Status: http.StatusNotFound,
+		Title:  "Short URL not found",
+		Detail: "Short URL by this id was not found.",
+	}
+	errorDisabled = &Error{
+		Status: http.StatusGone,
+		Title:  "Short URL disabled",
+		Detail: "This short URL has been disabled by its creator.",
+	}
+	errorForbidden = &Error{Status: http.StatusForbidden}
+)
+
+// errorResponse maps the error to its response. Database timeouts are
+// usually temporary, so they get a response asking to try again. Errors
+// without a response of their own are internal server errors.
+func errorResponse(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ErrNotFound):
+		return errorNotFound
+	case errors.Is(err, ErrDisabled):
+		return errorDisabled
+	case errors.Is(err, ErrReadOnly):
+		// Changes are not possible when serving an export.
+		return errorEOL
+	case errors.Is(err, ErrTimeout):
+		return &Error{Status: http.StatusServiceUnavailable, RetryAfter: 10 * time.Second, Err: err}
+	}
+	return &Error{Status: http.StatusInternalServerError, Err: err}
+}
+
+// handle adapts a handler that returns an error. The error is served as
+// mapped by errorResponse. Misses count against their own, stricter rate
+// limit to slow down scanning of the id space.
+func (c Config) handle(h func(w http.ResponseWriter, req *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		e := errorResponse(err)
+		if e.Status == http.StatusNotFound && !c.allow(w, req, limitNotFound) {
+			return
+		}
+		e.ServeHTTP(w, req)
+	})
+}
+
+// serveError responds to the error as mapped by errorResponse.
+func serveError(w http.ResponseWriter, req *http.Request, err error) {
+	errorResponse(err).ServeHTTP(w, req)
+}
+
+// ServeHTTP responds with the error page, or with a problem JSON or plain
+// text body for API clients.
+func (e *Error) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if e.Status >= 500 {
+		log.Printf("ERROR HTTP %d: %v", e.Status, e.Err)
+	}
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+	title, detail := e.texts()
+	switch errorFormat(req) {
+	case formatJSON:
+		w.Header().Set("Content-Type", problemJSON)
+		w.WriteHeader(e.Status)
+		err := json.NewEncoder(w).Encode(problem{
+			Type:     "about:blank",
+			Title:    title,
+			Status:   e.Status,
+			Detail:   detail,
+			Instance: requestPath(req),
+		})
+		if err != nil {
+			log.Printf("writing error: %v", err)
+		}
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(e.Status)
+		fmt.Fprintf(w, "%d %s\n%s\n", e.Status, title, detail)
+	default:
+		template := "error.html"
+		if name := errorTemplate(e.Status); hasTemplate(name) {
+			template = name
+		}
+		response{
+			Template:   template,
+			StatusCode: e.Status,
+			Context: map[string]interface{}{
+				"Status":       e.Status,
+				"ErrorTitle":   title,
+				"ErrorMessage": detail,
+			},
+		}.ServeHTTP(w, req)
+	}
+}
+
+// problem is an error response body for API clients, as in RFC 7807.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// requestPath is the path of the request as sent by the client, before
+// any prefix was stripped by the handlers.
+func requestPath(req *http.Request) string {
+	if u, err := url.ParseRequestURI(req.RequestURI); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return req.URL.Path
+}
+
+// errorFormat is the format of an error response. Clients preferring
+// application/problem+json get JSON, otherwise the response format of the
+// request is used if set, or negotiated from the Accept header.
+func errorFormat(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+	if accept != "" && acceptQuality(accept, problemJSON) > acceptQuality(accept, "text/html") {
+		return formatJSON
+	}
+	if f, ok := req.Context().Value(formatKey{}).(string); ok {
+		return f
+	}
+	return negotiateFormat(req)
+}
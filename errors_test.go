@@ -0,0 +1,143 @@
+package shorturl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errorResponseTests = []struct {
+	err    error
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{fmt.Errorf("looking up: %w", ErrNotFound), http.StatusNotFound},
+	{ErrDisabled, http.StatusGone},
+	{ErrReadOnly, http.StatusGone},
+	{ErrTimeout, http.StatusServiceUnavailable},
+	{&Error{Status: http.StatusUnavailableForLegalReasons}, http.StatusUnavailableForLegalReasons},
+	{errors.New("broken"), http.StatusInternalServerError},
+}
+
+func TestErrorResponse(t *testing.T) {
+	for _, c := range errorResponseTests {
+		if got := errorResponse(c.err).Status; got != c.status {
+			t.Errorf("errorResponse(%v) status = %d, want %d", c.err, got, c.status)
+		}
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	for status := range errorPages {
+		w := httptest.NewRecorder()
+		(&Error{Status: status}).ServeHTTP(w, httptest.NewRequest("GET", "http://yx.fi/x", nil))
+		title, _ := (&Error{Status: status}).texts()
+		if w.Code != status || !strings.Contains(w.Body.String(), "<h2>"+title+"</h2>") {
+			t.Errorf("%d: status %d, page does not have title %q", status, w.Code, title)
+		}
+	}
+	w := httptest.NewRecorder()
+	errorNotFound.ServeHTTP(w, httptest.NewRequest("GET", "http://yx.fi/x", nil))
+	if !strings.Contains(w.Body.String(), "front page") {
+		t.Error("404 not rendered with 404.html")
+	}
+}
+
+func TestErrorPageOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer LoadTemplates("")
+
+	page := `{{define "Head"}}{{end}}{{define "Body"}}<p>Removed by court order {{.Data.Status}}</p>{{end}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "451.html"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckTemplates(dir); err != nil {
+		t.Fatalf("CheckTemplates() = %v", err)
+	}
+	if err := LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	(&Error{Status: http.StatusUnavailableForLegalReasons}).ServeHTTP(w, httptest.NewRequest("GET", "http://yx.fi/x", nil))
+	if !strings.Contains(w.Body.String(), "Removed by court order 451") {
+		t.Errorf("451 page not rendered with 451.html:\n%s", w.Body.String())
+	}
+}
+
+func TestProblemJSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://yx.fi/x", nil)
+	req.Header.Set("Accept", "application/problem+json, text/html;q=0.5")
+	w := httptest.NewRecorder()
+	(&Error{Status: http.StatusTooManyRequests, RetryAfter: 1500 * time.Millisecond}).ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Type"); got != problemJSON {
+		t.Errorf("Content-Type = %q, want %q", got, problemJSON)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := problem{Type: "about:blank", Title: "Too many requests", Status: 429,
+		Detail: errorPages[429].Detail, Instance: "/x"}
+	if p != want {
+		t.Errorf("problem = %+v, want %+v", p, want)
+	}
+
+	// Browsers accept anything, but prefer HTML.
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	w = httptest.NewRecorder()
+	errorForbidden.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Type"); strings.Contains(got, "json") {
+		t.Errorf("browser got error as %s", got)
+	}
+}
+
+var handlerProblemTests = []struct {
+	path   string
+	accept string
+}{
+	{"/p/zzzz", problemJSON},
+	{"/p/zzzz.json", ""},
+	{"/zzzz", problemJSON},
+}
+
+func TestHandlerProblemJSON(t *testing.T) {
+	table, err := parseSnapshot(testSnapshot(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler(&Snapshot{table: table}, testConfig(t))
+	for _, c := range handlerProblemTests {
+		req := httptest.NewRequest("GET", "http://yx.fi"+c.path, nil)
+		req.Header.Set("Accept", c.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Type"); got != problemJSON {
+			t.Errorf("%s: Content-Type = %q, want %q", c.path, got, problemJSON)
+			continue
+		}
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Errorf("%s: %v", c.path, err)
+			continue
+		}
+		// The instance is the path requested, before any prefix is stripped.
+		if p.Status != http.StatusNotFound || p.Instance != c.path {
+			t.Errorf("%s: problem = %+v, want status 404 and instance %s", c.path, p, c.path)
+		}
+	}
+}
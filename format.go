@@ -0,0 +1,189 @@
+package shorturl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response formats of the preview page
+const (
+	formatHTML = "html"
+	formatJSON = "json"
+	formatText = "text"
+)
+
+// formatExts are the preview URL suffixes selecting a response format.
+var formatExts = map[string]string{
+	".json": formatJSON,
+	".txt":  formatText,
+}
+
+// formatTypes are the media types of the formats, in order of preference
+// when the client accepts several equally.
+var formatTypes = []struct {
+	format    string
+	mediaType string
+}{
+	{formatHTML, "text/html"},
+	{formatJSON, "application/json"},
+	{formatText, "text/plain"},
+}
+
+type formatKey struct{}
+
+// withFormat sets the response format for the handlers of the request.
+// Error responses are written in this format.
+func withFormat(req *http.Request, format string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), formatKey{}, format))
+}
+
+func requestFormat(req *http.Request) string {
+	if f, ok := req.Context().Value(formatKey{}).(string); ok {
+		return f
+	}
+	return formatHTML
+}
+
+// negotiateFormat picks the response format from the Accept header. HTML is
+// used if the client accepts none of the formats.
+func negotiateFormat(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return formatHTML
+	}
+	best, bestQ := formatHTML, 0.0
+	for _, t := range formatTypes {
+		if q := acceptQuality(accept, t.mediaType); q > bestQ {
+			best, bestQ = t.format, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality value given to mediaType in the Accept
+// header. The most specific matching media range applies.
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	major := strings.SplitN(mediaType, "/", 2)[0]
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+		var s int
+		switch rangeType {
+		case mediaType:
+			s = 2
+		case major + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+		rq := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					rq = v
+				}
+			}
+		}
+		q, specificity = rq, s
+	}
+	return q
+}
+
+// previewJSON is the JSON description of a short url.
+type previewJSON struct {
+	UID           string     `json:"uid"`
+	ShortURL      string     `json:"short_url"`
+	PreviewURL    string     `json:"preview_url"`
+	URL           string     `json:"url"`
+	DisplayURL    string     `json:"display_url"`
+	FinalURL      string     `json:"final_url,omitempty"`
+	TargetDomain  string     `json:"target_domain"`
+	Added         time.Time  `json:"added"`
+	Warning       string     `json:"warning,omitempty"`
+	DomainWarning string     `json:"domain_warning,omitempty"`
+	Check         *checkJSON `json:"check,omitempty"`
+	Dead          bool       `json:"dead"`
+	ArchiveURL    string     `json:"archive_url,omitempty"`
+	Archived      bool       `json:"archived"`
+}
+
+// checkJSON is the JSON description of the latest link check.
+type checkJSON struct {
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Redirects []string  `json:"redirects,omitempty"`
+	Checked   time.Time `json:"checked"`
+	Failures  int       `json:"failures,omitempty"`
+	Dead      bool      `json:"dead"`
+}
+
+// writePreview writes the preview of the short url in JSON or plain text.
+func writePreview(w http.ResponseWriter, req *http.Request, p previewContext, format string) {
+	base := baseURL(req)
+	if format == formatJSON {
+		w.Header().Set("Content-Type", "application/json")
+		var check *checkJSON
+		if c := p.Check; c != nil {
+			check = &checkJSON{c.Status, c.Error, c.Redirects, c.Checked, c.Failures, c.Dead()}
+		}
+		var domainWarning string
+		if warning := p.DomainWarning(); warning != nil {
+			domainWarning = warning.Error()
+		}
+		err := json.NewEncoder(w).Encode(previewJSON{
+			UID:           p.UID(),
+			ShortURL:      base + "/" + p.UID(),
+			PreviewURL:    base + p.PreviewURL(),
+			URL:           p.URL,
+			DisplayURL:    p.DisplayURL(),
+			FinalURL:      p.FinalURL,
+			TargetDomain:  p.DisplayDomain(),
+			Added:         p.Added,
+			Warning:       p.Warning,
+			DomainWarning: domainWarning,
+			Check:         check,
+			Dead:          p.TargetDead(),
+			ArchiveURL:    p.ArchiveURL,
+			Archived:      p.Archived,
+		})
+		if err != nil {
+			log.Printf("writing preview: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Short URL: %s/%s\n", base, p.UID())
+	fmt.Fprintf(w, "Target:    %s\n", p.DisplayURL())
+	if p.FinalURL != "" {
+		fmt.Fprintf(w, "Final:     %s\n", p.FinalURL)
+	}
+	fmt.Fprintf(w, "Added:     %s\n", formatTime(&p.Added, "2006-01-02 15:04:05 MST", readPrefs(req).Location()))
+	if p.Warning != "" {
+		fmt.Fprintf(w, "Warning:   the target is reported as malicious: %s\n", p.Warning)
+	}
+	if warning := p.DomainWarning(); warning != nil {
+		fmt.Fprintf(w, "Warning:   %s\n", warning)
+	}
+	if c := p.Check; c != nil {
+		fmt.Fprintf(w, "Checked:   %s %s\n", formatTime(&c.Checked, "2006-01-02", readPrefs(req).Location()), c.Summary())
+	}
+	if p.ArchiveURL != "" && p.TargetDead() {
+		fmt.Fprintf(w, "Archive:   %s\n", p.ArchiveURL)
+	}
+	if p.Archived {
+		fmt.Fprintln(w, "The target is gone, so the short URL redirects to the archive.")
+	}
+}
@@ -0,0 +1,74 @@
+package shorturl
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var negotiateTests = []struct {
+	accept string
+	want   string
+}{
+	{"", formatHTML},
+	{"*/*", formatHTML},
+	{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatHTML},
+	{"application/json", formatJSON},
+	{"application/json, text/plain;q=0.5", formatJSON},
+	{"text/plain", formatText},
+	{"text/plain, */*;q=0.1", formatText},
+	{"text/*;q=0.5, application/json;q=0.4", formatHTML},
+	{"text/html;q=0, */*", formatJSON},
+	{"image/png", formatHTML},
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	for _, c := range negotiateTests {
+		req := httptest.NewRequest("GET", "/p/a", nil)
+		req.Header.Set("Accept", c.accept)
+		if got := negotiateFormat(req); got != c.want {
+			t.Errorf("negotiateFormat(%q) = %s, want %s", c.accept, got, c.want)
+		}
+	}
+}
+
+var errorFormatTests = []struct {
+	format string
+	body   string
+}{
+	{formatHTML, "<h2>Short URL not found</h2>"},
+	{formatJSON, `{"type":"about:blank","title":"Short URL not found","status":404,"detail":"Short URL by this id was not found.","instance":"/p/a"}`},
+	{formatText, "404 Short URL not found\nShort URL by this id was not found.\n"},
+}
+
+func TestErrorFormats(t *testing.T) {
+	for _, c := range errorFormatTests {
+		w := httptest.NewRecorder()
+		req := withFormat(httptest.NewRequest("GET", "/p/a", nil), c.format)
+		errorNotFound.ServeHTTP(w, req)
+		if w.Code != 404 {
+			t.Errorf("%s: status = %d, want 404", c.format, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("%s: body %q does not contain %q", c.format, w.Body.String(), c.body)
+		}
+	}
+}
+
+func TestWritePreviewJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://yx.fi/p/za.json", nil)
+	p := previewContext{Shorturl: &Shorturl{ID: 1270, URL: "http://xn--bcher-kva.example/"}}
+	writePreview(w, req, p, formatJSON)
+	var got previewJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ShortURL != "http://yx.fi/za" || got.URL != p.URL || got.TargetDomain != "bücher.example" {
+		t.Errorf("unexpected preview: %+v", got)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+}
@@ -1,5 +1,12 @@
 module github.com/joneskoo/shorturl-go
 
-go 1.12
+go 1.17
 
-require github.com/lib/pq v1.9.0
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/lib/pq v1.9.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/net v0.11.0
+)
+
+require golang.org/x/text v0.10.0 // indirect
@@ -1,78 +1,400 @@
 package shorturl
 
 import (
-	"bytes"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
-
-	"github.com/joneskoo/shorturl-go/assets"
+	"time"
 )
 
-func Handler(db *DB, secure bool) http.Handler {
+// Config holds the settings of the HTTP handler.
+type Config struct {
+	// Secure is set when the service is used over https.
+	Secure bool
+	// RateLimiter limits requests per client. Nil disables rate limiting.
+	RateLimiter *RateLimiter
+	// Blocklist lists malicious targets that get a warning page instead of
+	// a redirect. Nil disables the check.
+	Blocklist *Blocklist
+	// Cookies encodes the cookies of the service. If nil, a random key is
+	// used and cookies are invalidated when the server restarts.
+	Cookies *CookieCodec
+	// ResolveHops is the number of redirects followed to find the final
+	// destination of a new short url. Zero disables resolving.
+	ResolveHops int
+	// Client makes the requests to resolve redirects. Nil uses a client
+	// that only connects to public addresses.
+	Client *http.Client
+	// Snapshot is used for redirects while the database is unavailable.
+	// Nil disables the fallback.
+	Snapshot *Snapshot
+	// ArchiveFallback redirects to the archived copy of the target when
+	// the target is dead and the short url has an archive URL.
+	ArchiveFallback bool
+	// RedirectStatus is the status code of redirects for short urls that
+	// do not set their own. The default is 302 Found.
+	RedirectStatus int
+	// PermanentMaxAge is how long permanent redirects may be cached.
+	PermanentMaxAge time.Duration
+	// CreatorGracePeriod is how long after adding a short url its creator
+	// may still delete or disable it.
+	CreatorGracePeriod time.Duration
+	// TrustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For header is trusted to carry the client address.
+	TrustedProxies []*net.IPNet
+
+	// AllowCreate enables adding new short urls. The service is end of
+	// life, so by default only existing short urls are served.
+	AllowCreate bool
+	// AllowedSchemes are the URL schemes that may be shortened.
+	AllowedSchemes []string
+	// StripTracking ignores tracking query parameters when looking for an
+	// existing short url for the same target.
+	StripTracking bool
+}
+
+func Handler(db Store, c Config) http.Handler {
+	if c.Cookies == nil {
+		c.Cookies, _ = NewCookieCodec([][]byte{RandomCookieKey()}, false)
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		switch req.URL.Path {
 		case "/":
+			if c.AllowCreate {
+				indexPage(http.StatusOK, nil).ServeHTTP(w, req)
+				return
+			}
 			// Index page: This service is end of life.
 			errorEOL.ServeHTTP(w, req)
 		default:
 			// If shorturl exists, redirect to it.
-			shorturlHandler(db).ServeHTTP(w, req)
+			shorturlHandler(db, c).ServeHTTP(w, req)
 		}
 	})
-	mux.Handle("/p/", http.StripPrefix("/p", previewHandler(db)))
-	mux.Handle("/static/style.css", staticHandler("css/style.css"))
-	return mux
+	mux.Handle("/add/", addHandler(db, c))
+	mux.Handle("/prefs", prefsHandler(c))
+	mux.Handle("/mine", myLinksHandler(db, c))
+	mux.Handle("/oembed", oembedHandler(db, c))
+	mux.Handle("/p/", http.StripPrefix("/p", previewHandler(db, c)))
+	mux.Handle("/static/", staticHandler(static))
+	return withPrefs(c, mux)
 }
 
-func shorturlHandler(db *DB) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+func shorturlHandler(db Store, c Config) http.Handler {
+	return c.handle(func(w http.ResponseWriter, req *http.Request) error {
 		if alwaysPreviewPref(req) && !isLocalReferer(req) {
-			previewHandler(db).ServeHTTP(w, req)
-			return
+			// Others are redirected from the same url, so the preview
+			// must not be kept in shared caches.
+			w.Header().Set("Cache-Control", "private, no-cache")
+			previewHandler(db, c).ServeHTTP(w, req)
+			return nil
 		}
-		shortCode := req.URL.Path[1:]
-		s, err := db.Get(shortCode)
-		switch err {
-		case ErrNotFound:
-			errorNotFound.ServeHTTP(w, req)
-		case nil:
-			http.Redirect(w, req, s.URL, http.StatusFound)
-		default:
-			log.Printf("ERROR HTTP 500: %v", err)
-			internalError.ServeHTTP(w, req)
+		if !c.allow(w, req, limitRedirect) {
+			return nil
+		}
+		shortCode, extraPath := splitCode(req.URL.EscapedPath())
+		var s *Shorturl
+		var err error
+		fromSnapshot := false
+		if c.Snapshot != nil {
+			s, fromSnapshot, err = c.Snapshot.lookup(req.Context(), db, shortCode)
+		} else {
+			s, err = db.Get(req.Context(), shortCode)
 		}
+		if err == nil && extraPath != "" && s.Passthrough == PassthroughOff {
+			err = ErrNotFound
+		}
+		if err == nil && s.Disabled {
+			err = ErrDisabled
+		}
+		if err != nil {
+			return err
+		}
+		target, err := s.Target(extraPath, req.URL.RawQuery)
+		if err != nil {
+			return ErrNotFound
+		}
+		if reason, blocked := c.Blocklist.Match(target); blocked {
+			// Interstitial warning instead of redirect.
+			previewPage(previewContext{Shorturl: s, Warning: reason}).ServeHTTP(w, req)
+			return nil
+		}
+		// Hits are not counted while the database is down.
+		if !fromSnapshot {
+			if err := db.Hit(req.Context(), s); err != nil {
+				log.Printf("counting hit: %v", err)
+			}
+		}
+		if c.archiveFallback(s) {
+			// The target may come back, so the redirect to the
+			// archive is never cached.
+			c.redirect(w, req, http.StatusFound, s.ArchiveURL)
+			return nil
+		}
+		c.redirect(w, req, s.RedirectStatus, target)
+		return nil
 	})
 }
 
 // previewHandler shows short url details page.
 // The page is shown after adding a short URL or when preview URL is explicitly
-// requested, or if always preview preference is set.
-func previewHandler(db *DB) http.Handler {
+// requested, or if always preview preference is set. With .png or .svg
+// suffix, a QR code of the short url is shown instead.
+//
+// The details are also available as JSON or plain text, selected with .json
+// or .txt suffix or the Accept header. Errors use the same format.
+func previewHandler(db Store, c Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		s, err := db.Get(req.URL.Path[1:])
-		switch err {
-		case ErrNotFound:
-			errorNotFound.ServeHTTP(w, req)
-		case nil:
-			response{
-				Template:   "preview.html",
-				Context:    s,
-				StatusCode: http.StatusOK,
-			}.ServeHTTP(w, req)
+		shortCode, _ := splitCode(req.URL.Path)
+		ext := path.Ext(shortCode)
+		format, ok := formatExts[ext]
+		_, qr := qrFormats[ext]
+		switch {
+		case qr:
+			// Errors for images are plain text.
+			format = formatText
+			fallthrough
+		case ok:
+			shortCode = strings.TrimSuffix(shortCode, ext)
 		default:
-			internalError.ServeHTTP(w, req)
+			format = negotiateFormat(req)
+			w.Header().Add("Vary", "Accept")
+		}
+		req = withFormat(req, format)
+		if req.FormValue("created") != "" {
+			req = req.WithContext(readPrimary(req.Context()))
+		}
+		c.handle(func(w http.ResponseWriter, req *http.Request) error {
+			if !c.allow(w, req, limitPreview) {
+				return nil
+			}
+			s, err := db.Get(req.Context(), shortCode)
+			if err == nil && s.Disabled {
+				err = ErrDisabled
+			}
+			if err != nil {
+				return err
+			}
+			if qr {
+				return serveQR(w, req, s, ext)
+			}
+			reason, _ := c.Blocklist.Match(s.URL)
+			p := previewContext{Shorturl: s, Warning: reason, Archived: c.archiveFallback(s)}
+			if format != formatHTML {
+				writePreview(w, req, p, format)
+				return nil
+			}
+			previewPage(p).ServeHTTP(w, req)
+			return nil
+		}).ServeHTTP(w, req)
+	})
+}
+
+// previewContext is the template context of the preview page.
+type previewContext struct {
+	*Shorturl
+	// Warning is the reason the target is considered malicious, if it is.
+	Warning string
+	// Archived is set when the short url redirects to the archived copy
+	// of the target.
+	Archived bool
+}
+
+func previewPage(p previewContext) response {
+	return response{
+		Template:   "preview.html",
+		Context:    p,
+		StatusCode: http.StatusOK,
+	}
+}
+
+// addHandler creates a short url for the posted URL and redirects to its
+// preview page. If the URL was shortened before, the existing short url is
+// used.
+func addHandler(db Store, c Config) http.Handler {
+	return c.handle(func(w http.ResponseWriter, req *http.Request) error {
+		if !c.AllowCreate {
+			return errorEOL
+		}
+		if req.Method != "POST" {
+			http.Redirect(w, req, "/", http.StatusSeeOther)
+			return nil
 		}
+		if !c.allow(w, req, limitCreate) {
+			return nil
+		}
+		if !isLocalReferer(req) {
+			// Other sites must not add short urls in the name of the
+			// browser, as they are attributed to its creator cookie.
+			return errorForbidden
+		}
+		target := strings.TrimSpace(req.PostFormValue("url"))
+		canonical, err := c.checkTarget(target)
+		if err != nil {
+			return badTarget(w, req, err)
+		}
+		final, err := c.resolveTarget(req, target)
+		if err == nil {
+			if reason, blocked := c.Blocklist.Match(final); blocked {
+				err = msg("The URL redirects to a site reported as malicious: %s", reason)
+			}
+		}
+		if err != nil {
+			return badTarget(w, req, err)
+		}
+		s := &Shorturl{URL: target, Canonical: canonical, FinalURL: final}
+		if ip := c.clientIP(req); ip != nil {
+			s.Host = ip.String()
+		}
+		if s.Creator, err = c.creator(w, req); err != nil {
+			return err
+		}
+		if err := db.Add(req.Context(), s); err != nil {
+			return err
+		}
+		// The preview is read from the primary database, as replicas may
+		// not have the new short url yet.
+		http.Redirect(w, req, s.PreviewURL()+"?created=1", http.StatusSeeOther)
+		return nil
 	})
 }
 
-func staticHandler(name string) http.Handler {
+// badTarget shows why the URL cannot be shortened on the front page, or
+// responds to API clients with a bad request error.
+func badTarget(w http.ResponseWriter, req *http.Request, err error) error {
+	if errorFormat(req) != formatHTML {
+		return &Error{Status: http.StatusBadRequest, Detail: err.Error()}
+	}
+	indexPage(http.StatusBadRequest, err).ServeHTTP(w, req)
+	return nil
+}
+
+// checkTarget validates a URL to be shortened and returns its canonical
+// form. The error is shown to the user, translated.
+func (c Config) checkTarget(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" {
+		return "", msg("Not a valid URL")
+	}
+	allowed := false
+	for _, scheme := range c.AllowedSchemes {
+		allowed = allowed || strings.EqualFold(u.Scheme, scheme)
+	}
+	if !allowed {
+		return "", msg("URL scheme %s is not allowed", u.Scheme)
+	}
+	canonical, err := Canonicalize(target, c.StripTracking)
+	if err != nil {
+		return "", msg("Not a valid URL: %v", err)
+	}
+	if reason, blocked := c.Blocklist.Match(canonical); blocked {
+		return "", msg("The URL is reported as malicious: %s", reason)
+	}
+	return canonical, nil
+}
+
+func indexPage(status int, err error) response {
+	data := map[string]interface{}{}
+	if err != nil {
+		data["Error"] = err
+	}
+	return response{
+		Template:   "index.html",
+		Context:    data,
+		StatusCode: status,
+	}
+}
+
+// prefsHandler shows the preferences page and saves the posted preferences
+// in cookies.
+func prefsHandler(c Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		style := assets.MustAsset(name)
-		http.ServeContent(w, req, path.Base(name), assets.LastModified, bytes.NewReader(style))
+		data := map[string]interface{}{
+			"TimeZones": timeZones,
+			"Languages": languages,
+			"Saved":     req.FormValue("saved") != "",
+		}
+		if req.Method != "POST" {
+			response{Template: "prefs.html", Context: data, StatusCode: http.StatusOK}.ServeHTTP(w, req)
+			return
+		}
+		p := Prefs{
+			AlwaysPreview: req.PostFormValue("preview") == "true",
+			TimeZone:      req.PostFormValue("tz"),
+			Language:      req.PostFormValue("lang"),
+		}
+		var err error
+		switch {
+		case !isLocalReferer(req):
+			err = msg("Preferences can only be changed from this site")
+		case p.TimeZone != "" && p.Location() == nil:
+			err = msg("Unknown time zone %s", p.TimeZone)
+		case p.Language != "" && !supportedLanguage(p.Language):
+			err = msg("Unknown language %s", p.Language)
+		}
+		if err != nil {
+			data["Error"] = err
+			response{Template: "prefs.html", Context: data, StatusCode: http.StatusBadRequest}.ServeHTTP(w, req)
+			return
+		}
+		if err := c.savePrefs(w, p); err != nil {
+			serveError(w, req, err)
+			return
+		}
+		http.Redirect(w, req, "/prefs?saved=1", http.StatusSeeOther)
+	})
+}
+
+// myLinksHandler lists the short urls added by the browser, identified by
+// the creator cookie. During the grace period the creator may delete or
+// disable them.
+func myLinksHandler(db Store, c Config) http.Handler {
+	return c.handle(func(w http.ResponseWriter, req *http.Request) error {
+		creator := c.readCookie(req, creatorCookie, creatorMaxAge)
+		if req.Method == "POST" {
+			if creator == "" || !isLocalReferer(req) {
+				return errorForbidden
+			}
+			id, err := parseUID(req.PostFormValue("id"))
+			if err != nil {
+				return ErrNotFound
+			}
+			s := &Shorturl{ID: id, Creator: creator}
+			switch req.PostFormValue("action") {
+			case "delete":
+				err = db.Delete(req.Context(), s, c.CreatorGracePeriod)
+			case "disable", "enable":
+				s.Disabled = req.PostFormValue("action") == "disable"
+				err = db.SetDisabled(req.Context(), s, c.CreatorGracePeriod)
+			default:
+				err = ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+			http.Redirect(w, req, "/mine", http.StatusSeeOther)
+			return nil
+		}
+		var links []CreatedShorturl
+		if creator != "" {
+			var err error
+			if links, err = db.ByCreator(req.Context(), creator, c.CreatorGracePeriod); err != nil {
+				return err
+			}
+		}
+		response{
+			Template: "mine.html",
+			Context: map[string]interface{}{
+				"Links":       links,
+				"GracePeriod": c.CreatorGracePeriod,
+			},
+			StatusCode: http.StatusOK,
+		}.ServeHTTP(w, req)
+		return nil
 	})
 }
 
@@ -94,12 +416,15 @@ type response struct {
 }
 
 func (r response) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	template, ok := templates[r.Template]
+	lang := requestLanguage(req)
+	template, ok := lookupTemplate(r.Template, lang)
 	if !ok {
 		log.Printf("template %s not found", r.Template)
 		http.Error(rw, "", http.StatusInternalServerError)
 		return
 	}
+	rw.Header().Set("Content-Language", lang)
+	rw.Header().Add("Vary", "Accept-Language")
 	rw.WriteHeader(r.StatusCode)
 	protocol := "http://"
 	if isSecure(req) {
@@ -109,6 +434,7 @@ func (r response) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	err := template.Execute(rw, map[string]interface{}{
 		"Protocol": protocol,
 		"Domain":   host(req),
+		"Prefs":    readPrefs(req),
 		"Data":     r.Context,
 	})
 	if err != nil {
@@ -117,6 +443,14 @@ func (r response) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// baseURL is the protocol and host of the service, e.g. "https://yx.fi".
+func baseURL(req *http.Request) string {
+	if isSecure(req) {
+		return "https://" + host(req)
+	}
+	return "http://" + host(req)
+}
+
 // isSecure checks if request was done over HTTPS.
 func isSecure(req *http.Request) bool {
 	return req.Header.Get("X-Forwarded-Proto") == "https"
@@ -129,30 +463,3 @@ func host(req *http.Request) string {
 	}
 	return req.Host
 }
-
-var (
-	errorEOL = response{
-		Template:   "error.html",
-		StatusCode: http.StatusGone,
-		Context: map[string]string{
-			"ErrorTitle":   "Service is end-of-life",
-			"ErrorMessage": "This short url service is end of life. Existing redirects continue to work for now.",
-		},
-	}
-	errorNotFound = response{
-		Template:   "error.html",
-		StatusCode: http.StatusNotFound,
-		Context: map[string]string{
-			"ErrorTitle":   "Short URL not found",
-			"ErrorMessage": "Short URL by this id was not found.",
-		},
-	}
-	internalError = response{
-		Template:   "error.html",
-		StatusCode: 500,
-		Context: map[string]string{
-			"ErrorTitle":   "Internal server error",
-			"ErrorMessage": "There was an error and we failed to handle it. Sorry.",
-		},
-	}
-)
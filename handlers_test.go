@@ -0,0 +1,141 @@
+package shorturl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMyLinksPage(t *testing.T) {
+	links := []CreatedShorturl{
+		{Shorturl: &Shorturl{ID: 10, URL: "https://www.example.com/", Hits: 42}, Editable: true},
+		{Shorturl: &Shorturl{ID: 11, URL: "https://example.org/", Disabled: true}},
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://yx.fi/mine", nil)
+	response{
+		Template: "mine.html",
+		Context: map[string]interface{}{
+			"Links":       links,
+			"GracePeriod": 24 * time.Hour,
+		},
+		StatusCode: http.StatusOK,
+	}.ServeHTTP(w, req)
+	body := w.Body.String()
+	for _, want := range []string{
+		`href="/p/a"`, "www.example.com", "42", "within 24 hours",
+		`value="disable"`, "disabled",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("my links page does not contain %q", want)
+		}
+	}
+	if strings.Count(body, `value="delete"`) != 1 {
+		t.Error("delete offered for short url past grace period")
+	}
+}
+
+func TestMyLinksRequiresCreator(t *testing.T) {
+	req := httptest.NewRequest("POST", "http://yx.fi/mine", strings.NewReader("id=a&action=delete"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "http://yx.fi/mine")
+	w := httptest.NewRecorder()
+	myLinksHandler(nil, testConfig(t)).ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAddRequiresLocalReferer(t *testing.T) {
+	c := testConfig(t)
+	c.AllowCreate = true
+	req := httptest.NewRequest("POST", "http://yx.fi/add/", strings.NewReader("url=https://www.example.com/"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "https://evil.example/")
+	w := httptest.NewRecorder()
+	addHandler(nil, c).ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestPreviewMetadata(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "https://yx.fi/p/za", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	s := &Shorturl{ID: 1270, URL: "https://www.example.com/page", Added: time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)}
+	previewPage(previewContext{Shorturl: s}).ServeHTTP(w, req)
+	body := w.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="yx.fi/za">`,
+		`<meta property="og:description" content="Short URL to www.example.com, added 2015-03-01.">`,
+		`<meta property="og:image" content="https://yx.fi/p/za.png?size=512">`,
+		`<meta name="twitter:card" content="summary">`,
+		`href="https://yx.fi/oembed?format=json&amp;url=https%3a%2f%2fyx.fi%2fza"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("preview page does not contain %s", want)
+		}
+	}
+}
+
+func TestPreviewLinkCheck(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://yx.fi/p/za", nil)
+	s := &Shorturl{ID: 1270, URL: "https://www.example.com/page", Check: &LinkCheck{
+		Status:    http.StatusNotFound,
+		Redirects: []string{"https://example.com/moved"},
+		Checked:   time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+	}}
+	previewPage(previewContext{Shorturl: s}).ServeHTTP(w, req)
+	body := w.Body.String()
+	for _, want := range []string{"seems to be gone", "2020-05-01: 404 Not Found", "https://example.com/moved"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("preview page does not contain %q", want)
+		}
+	}
+}
+
+// oneStore is a Store with a single short url.
+type oneStore struct {
+	Store
+	s *Shorturl
+}
+
+func (o oneStore) Get(ctx context.Context, shortCode string) (*Shorturl, error) {
+	if shortCode != o.s.UID() {
+		return nil, ErrNotFound
+	}
+	return o.s, nil
+}
+
+func TestAlwaysPreviewPrivate(t *testing.T) {
+	db := oneStore{s: &Shorturl{ID: 1270, URL: "https://www.example.com/page"}}
+	req := httptest.NewRequest("GET", "http://yx.fi/za", nil)
+	req = req.WithContext(context.WithValue(req.Context(), prefsKey{}, Prefs{AlwaysPreview: true}))
+	w := httptest.NewRecorder()
+	shorturlHandler(db, Config{}).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want preview page", w.Code)
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+		t.Errorf("Cache-Control = %q, want private", got)
+	}
+}
+
+func TestServerErrorTimeout(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveError(w, httptest.NewRequest("GET", "http://yx.fi/a", nil), ErrTimeout)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("timeout response %d with Retry-After %q, want 503", w.Code, w.Header().Get("Retry-After"))
+	}
+	w = httptest.NewRecorder()
+	serveError(w, httptest.NewRequest("GET", "http://yx.fi/a", nil), errors.New("broken"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("error response %d, want 500", w.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package shorturl
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joneskoo/shorturl-go/assets"
+)
+
+// defaultLanguage is used when the browser accepts none of the languages.
+// The texts in the code and templates are in this language.
+const defaultLanguage = "en"
+
+// Language is a language the pages are available in.
+type Language struct {
+	// Tag is the language code, e.g. "fi".
+	Tag string
+	// Name is the name of the language in itself.
+	Name string
+}
+
+// languages are the supported languages, in the order offered on the
+// preferences page.
+var languages = []Language{
+	{"en", "English"},
+	{"fi", "suomi"},
+}
+
+// catalogs translate the English texts, by language. They are read from
+// assets/locales/<tag>.json, which maps the English text to the translation.
+var catalogs = mustLoadCatalogs(assets.FS, "locales")
+
+func mustLoadCatalogs(fsys fs.FS, dir string) map[string]map[string]string {
+	c, err := loadCatalogs(fsys, dir)
+	if err != nil {
+		log.Fatalf("Loading translations: %v", err)
+	}
+	return c
+}
+
+func loadCatalogs(fsys fs.FS, dir string) (map[string]map[string]string, error) {
+	c := make(map[string]map[string]string)
+	for _, lang := range languages {
+		if lang.Tag == defaultLanguage {
+			continue
+		}
+		name := dir + "/" + lang.Tag + ".json"
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		c[lang.Tag] = catalog
+	}
+	return c, nil
+}
+
+// message is a text shown to users, translated to their language. The
+// English format is the key in the catalogs.
+type message struct {
+	format string
+	args   []interface{}
+}
+
+// msg returns a message to translate. As an error, it reads in English.
+func msg(format string, args ...interface{}) message {
+	return message{format, args}
+}
+
+func (m message) Error() string {
+	return m.in(defaultLanguage)
+}
+
+// in returns the message in the language. Messages without a translation
+// are in English. Arguments that are messages are translated too.
+func (m message) in(lang string) string {
+	format := m.format
+	if t, ok := catalogs[lang][format]; ok {
+		format = t
+	}
+	if len(m.args) == 0 {
+		return format
+	}
+	args := make([]interface{}, len(m.args))
+	for i, arg := range m.args {
+		if nested, ok := arg.(message); ok {
+			arg = nested.in(lang)
+		}
+		args[i] = arg
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// translate returns the text in the language. Text may be a message, an
+// error or a string, which are looked up in the catalog as is.
+func translate(lang string, text interface{}, args ...interface{}) string {
+	switch t := text.(type) {
+	case message:
+		return t.in(lang)
+	case error:
+		return msg(t.Error()).in(lang)
+	case string:
+		return msg(t, args...).in(lang)
+	}
+	return fmt.Sprint(text)
+}
+
+// languageFuncs are the template functions that depend on the language of
+// the page. "t" translates a text, formatting it with the arguments, and
+// "formattime" translates the layout, e.g. to write dates the Finnish way.
+func languageFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"lang": func() string { return lang },
+		"t": func(text interface{}, args ...interface{}) string {
+			return translate(lang, text, args...)
+		},
+		"formattime": func(t *time.Time, layout string, loc *time.Location) string {
+			return formatTime(t, msg(layout).in(lang), loc)
+		},
+	}
+}
+
+// supportedLanguage reports whether the pages are available in the
+// language.
+func supportedLanguage(tag string) bool {
+	for _, lang := range languages {
+		if lang.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// requestLanguage is the language of the pages: the language preference if
+// set, otherwise the best supported language in the Accept-Language header.
+func requestLanguage(req *http.Request) string {
+	if lang := readPrefs(req).Language; supportedLanguage(lang) {
+		return lang
+	}
+	return negotiateLanguage(req.Header.Get("Accept-Language"))
+}
+
+// negotiateLanguage picks the supported language with the highest quality
+// in the Accept-Language header value. Regional variants match their
+// language, e.g. fi-FI is Finnish.
+func negotiateLanguage(header string) string {
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, q := parseAcceptItem(part)
+		tag = strings.ToLower(tag)
+		if i := strings.IndexByte(tag, '-'); i >= 0 {
+			tag = tag[:i]
+		}
+		if q > bestQ && supportedLanguage(tag) {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
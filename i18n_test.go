@@ -0,0 +1,136 @@
+package shorturl
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joneskoo/shorturl-go/assets"
+)
+
+var negotiateLanguageTests = []struct {
+	header string
+	want   string
+}{
+	{"", "en"},
+	{"fi", "fi"},
+	{"fi-FI,fi;q=0.9,en;q=0.8", "fi"},
+	{"en-US,en;q=0.9,fi;q=0.8", "en"},
+	{"sv,fi;q=0.5", "fi"},
+	{"de,sv", "en"},
+	{"fi;q=0", "en"},
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	for _, c := range negotiateLanguageTests {
+		if got := negotiateLanguage(c.header); got != c.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", c.header, got, c.want)
+		}
+	}
+}
+
+func TestFinnishPage(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://yx.fi/p/za", nil)
+	req.Header.Set("Accept-Language", "fi-FI,fi;q=0.9,en;q=0.8")
+	s := &Shorturl{ID: 1270, URL: "https://www.example.com/page", Added: time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)}
+	w := httptest.NewRecorder()
+	previewPage(previewContext{Shorturl: s}).ServeHTTP(w, req)
+	body := w.Body.String()
+	for _, want := range []string{`<html lang="fi">`, "Lyhennetty osoite", "Lisätty ensimmäisen kerran 1.3.2015 klo 12.00.00 UTC.",
+		`<meta property="og:description" content="Lyhytosoite sivustolle www.example.com, lisätty 2015-03-01.">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Finnish preview page does not contain %q", want)
+		}
+	}
+	if got := w.Header().Get("Content-Language"); got != "fi" {
+		t.Errorf("Content-Language = %q, want fi", got)
+	}
+
+	// The preference wins over the browser languages.
+	req = req.WithContext(context.WithValue(req.Context(), prefsKey{}, Prefs{Language: "en"}))
+	w = httptest.NewRecorder()
+	previewPage(previewContext{Shorturl: s}).ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, `<html lang="en">`) || !strings.Contains(body, "Shortened URL") {
+		t.Error("language preference not used")
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	err := msg("URL scheme %s is not allowed", "ftp")
+	if got, want := err.Error(), "URL scheme ftp is not allowed"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if got, want := translate("fi", err), "Osoitteen skeema ftp ei ole sallittu"; got != want {
+		t.Errorf("translate(fi) = %q, want %q", got, want)
+	}
+	if got, want := translate("fi", "no translation"), "no translation"; got != want {
+		t.Errorf("translate(fi) = %q, want %q", got, want)
+	}
+	nested := msg("Short URL to %s, added %s. Warning: %s", "example.com", "2015-03-01",
+		msg("The domain name is not a valid internationalized domain name."))
+	if got, want := translate("fi", nested), "Lyhytosoite sivustolle example.com, lisätty 2015-03-01. Varoitus: Verkkotunnus ei ole kelvollinen kansainvälistetty verkkotunnus."; got != want {
+		t.Errorf("translate(fi) = %q, want %q", got, want)
+	}
+}
+
+// TestCatalogsComplete checks that the texts in the templates, the error
+// pages and the messages in the code have translations.
+func TestCatalogsComplete(t *testing.T) {
+	var texts []string
+	literal := regexp.MustCompile(`{{\s*t "([^"]+)"`)
+	files, err := fs.Glob(assets.FS, "templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		b, err := fs.ReadFile(assets.FS, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range literal.FindAllStringSubmatch(string(b), -1) {
+			texts = append(texts, m[1])
+		}
+	}
+	// Messages are found in the source, as they are mostly returned as errors.
+	msgLiteral := regexp.MustCompile(`\bmsg\(("(?:[^"\\]|\\.)*")`)
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range sources {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msgLiteral.FindAllStringSubmatch(string(b), -1) {
+			text, err := strconv.Unquote(m[1])
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			texts = append(texts, text)
+		}
+	}
+	for _, e := range []*Error{errorEOL, errorNotFound, errorDisabled} {
+		texts = append(texts, e.Title, e.Detail)
+	}
+	for _, page := range errorPages {
+		texts = append(texts, page.Title, page.Detail)
+	}
+	for lang, catalog := range catalogs {
+		for _, text := range texts {
+			if _, ok := catalog[text]; !ok {
+				t.Errorf("%s: no translation for %q", lang, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package shorturl
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// lookalikes maps Cyrillic and Greek letters to the Latin letters they are
+// easily mistaken for. A domain label made only of these looks like a Latin
+// name, e.g. "аррӏе" in Cyrillic.
+var lookalikes = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j',
+	'ӏ': 'l', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'у': 'y', 'ԝ': 'w',
+	'х': 'x',
+	// Greek
+	'α': 'a', 'ϲ': 'c', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'υ': 'u', 'χ': 'x',
+}
+
+// cjk are the scripts used for Chinese, Japanese and Korean, which are
+// normally written mixed with each other and with Latin.
+var cjk = map[string]bool{
+	"Han":      true,
+	"Hiragana": true,
+	"Katakana": true,
+	"Hangul":   true,
+	"Bopomofo": true,
+}
+
+// DisplayDomain is the target domain for showing to users. Internationalized
+// domain names are shown in Unicode, unless the name could be misleading, in
+// which case the punycode form is kept.
+func (s *Shorturl) DisplayDomain() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return ""
+	}
+	return displayHost(u)
+}
+
+// DisplayURL is the target URL with the domain shown as in DisplayDomain.
+func (s *Shorturl) DisplayURL() string {
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Host == "" {
+		return s.URL
+	}
+	// Formatting the URL would percent-encode a Unicode host, so the URL
+	// is formatted without it and the host is put in its place.
+	rest := *u
+	rest.Scheme, rest.User, rest.Host = "", nil, ""
+	prefix := u.Scheme + "://"
+	if u.User != nil {
+		prefix += u.User.String() + "@"
+	}
+	return prefix + displayHost(u) + rest.String()
+}
+
+// DomainWarning explains why the target domain may be misleading, as a
+// message to translate. It is nil for ordinary domain names.
+func (s *Shorturl) DomainWarning() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil
+	}
+	_, warning := checkDomain(u.Hostname())
+	return warning
+}
+
+func displayHost(u *url.URL) string {
+	host, warning := checkDomain(u.Hostname())
+	if warning != nil {
+		if ascii, err := idna.Lookup.ToASCII(u.Hostname()); err == nil {
+			host = ascii
+		} else {
+			host = u.Hostname()
+		}
+	}
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 addresses are in brackets also without a port.
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// checkDomain converts the domain name to Unicode and checks it for mixed
+// scripts and names resembling Latin ones. The warning is a message to
+// translate.
+func checkDomain(host string) (string, error) {
+	if host == "" || net.ParseIP(host) != nil {
+		return host, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return host, msg("The domain name is not a valid internationalized domain name.")
+	}
+	name, err := idna.Display.ToUnicode(ascii)
+	if err != nil {
+		return host, msg("The domain name is not a valid internationalized domain name.")
+	}
+	for _, label := range strings.Split(name, ".") {
+		scripts := labelScripts(label)
+		if mixedScripts(scripts) {
+			return name, msg("The domain name mixes %s characters, which is often used to imitate other sites.",
+				strings.Join(scripts, " and "))
+		}
+		if latin, ok := latinLookalike(label); ok {
+			return name, msg("The domain name uses %s characters that look like the Latin letters %q.",
+				scripts[0], latin)
+		}
+	}
+	return name, nil
+}
+
+// labelScripts returns the scripts used in a domain label. Digits and
+// hyphens belong to no script.
+func labelScripts(label string) []string {
+	seen := make(map[string]bool)
+	for _, r := range label {
+		if script := scriptOf(r); script != "" {
+			seen[script] = true
+		}
+	}
+	var scripts []string
+	for script := range seen {
+		scripts = append(scripts, script)
+	}
+	sort.Strings(scripts)
+	return scripts
+}
+
+// mixedScripts reports whether the scripts are unusual to see together.
+// Latin is commonly written together with the CJK scripts.
+func mixedScripts(scripts []string) bool {
+	if len(scripts) < 2 {
+		return false
+	}
+	for _, script := range scripts {
+		if !cjk[script] && script != "Latin" {
+			return true
+		}
+	}
+	return false
+}
+
+// latinLookalike returns the Latin spelling of a label written entirely in
+// letters resembling Latin ones.
+func latinLookalike(label string) (string, bool) {
+	var latin []rune
+	for _, r := range label {
+		if l, ok := lookalikes[r]; ok {
+			latin = append(latin, l)
+		} else if unicode.IsLetter(r) {
+			return "", false
+		} else {
+			latin = append(latin, r)
+		}
+	}
+	return string(latin), len(latin) > 0 && string(latin) != label
+}
+
+func scriptOf(r rune) string {
+	if r < unicode.MaxASCII {
+		if unicode.IsLetter(r) {
+			return "Latin"
+		}
+		return ""
+	}
+	for name, table := range unicode.Scripts {
+		if name == "Common" || name == "Inherited" {
+			continue
+		}
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
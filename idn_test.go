@@ -0,0 +1,57 @@
+package shorturl
+
+import "testing"
+
+var idnTests = []struct {
+	url     string
+	display string
+	warn    bool
+}{
+	{"http://www.example.com/", "www.example.com", false},
+	{"http://xn--bcher-kva.example/", "bücher.example", false},
+	{"http://bücher.example/", "bücher.example", false},
+	{"http://xn--r8jz45g.jp/", "例え.jp", false},
+	{"http://example.com:8080/", "example.com:8080", false},
+	{"http://192.0.2.1/", "192.0.2.1", false},
+	{"http://[2001:db8::1]/", "[2001:db8::1]", false},
+	// Cyrillic "аррӏе" looks like Latin "apple".
+	{"http://xn--80ak6aa92e.com/", "xn--80ak6aa92e.com", true},
+	// Latin "p" and "ypal" with Cyrillic "а".
+	{"http://xn--pypal-4ve.com/", "xn--pypal-4ve.com", true},
+	// Cyrillic word that does not look like Latin.
+	{"http://xn--d1acpjx3f.xn--p1ai/", "яндекс.рф", false},
+}
+
+func TestDisplayDomain(t *testing.T) {
+	for _, c := range idnTests {
+		s := &Shorturl{URL: c.url}
+		if got := s.DisplayDomain(); got != c.display {
+			t.Errorf("DisplayDomain(%s) = %s, want %s", c.url, got, c.display)
+		}
+		if warning := s.DomainWarning(); (warning != nil) != c.warn {
+			t.Errorf("DomainWarning(%s) = %v, want warning %v", c.url, warning, c.warn)
+		}
+	}
+}
+
+var displayURLTests = []struct {
+	url  string
+	want string
+}{
+	{"https://xn--bcher-kva.example/path?q=1", "https://bücher.example/path?q=1"},
+	{"https://bücher.example:8443/path", "https://bücher.example:8443/path"},
+	{"http://xn--80ak6aa92e.com/", "http://xn--80ak6aa92e.com/"},
+	{"https://xn--bcher-kva.example@bücher.example/", "https://xn--bcher-kva.example@bücher.example/"},
+	{"http://[2001:db8::1]/a", "http://[2001:db8::1]/a"},
+	{"http://[2001:db8::1]:8080/a", "http://[2001:db8::1]:8080/a"},
+	{"spotify:track:6rqhFgbbKwnb9MLmUQDhG6", "spotify:track:6rqhFgbbKwnb9MLmUQDhG6"},
+}
+
+func TestDisplayURL(t *testing.T) {
+	for _, c := range displayURLTests {
+		s := &Shorturl{URL: c.url}
+		if got := s.DisplayURL(); got != c.want {
+			t.Errorf("DisplayURL(%s) = %s, want %s", c.url, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package shorturl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Link checker defaults
+const (
+	linkCheckMaxHops     = 10
+	linkCheckTimeout     = 15 * time.Second
+	linkCheckConcurrency = 4
+	linkCheckDomainDelay = 2 * time.Second
+	linkCheckBatch       = 500
+	linkCheckUserAgent   = "yxfi-linkcheck/1.0"
+	// linkCheckDeadAfter is the number of failed checks in a row after
+	// which a target that responds with errors or not at all is dead.
+	linkCheckDeadAfter = 3
+	// linkCheckRetry is how soon a link that failed is checked again.
+	linkCheckRetry = 6 * time.Hour
+)
+
+// Link check errors for redirect chains
+const (
+	errRedirectLoop     = "redirect loop"
+	errTooManyRedirects = "too many redirects"
+)
+
+// LinkCheck is the result of checking that the target of a short url still
+// responds.
+type LinkCheck struct {
+	// Status is the status code of the final response, or zero if no
+	// response was received.
+	Status int
+	// Error describes why no final response was received.
+	Error string
+	// Redirects are the URLs the target redirected through, in order.
+	Redirects []string
+	// Checked is the time of the check.
+	Checked time.Time
+	// Failures is the number of checks in a row, up to this one, in which
+	// the target failed.
+	Failures int
+}
+
+// Failed reports whether no response was received or the response was a
+// server error.
+func (c *LinkCheck) Failed() bool {
+	return c != nil && (c.Error != "" || c.Status >= 500)
+}
+
+// Dead reports whether the target is gone: it is not found, or it has
+// failed linkCheckDeadAfter checks in a row. A single failure is not
+// enough, as sites have outages and maintenance breaks. Responses denying
+// access are not counted, as sites often deny automated requests.
+func (c *LinkCheck) Dead() bool {
+	if c == nil {
+		return false
+	}
+	switch c.Status {
+	case http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return c.Failed() && c.Failures >= linkCheckDeadAfter
+}
+
+// Summary describes the result of the check in a few words.
+func (c *LinkCheck) Summary() string {
+	if c.Error != "" {
+		return "unreachable: " + c.Error
+	}
+	return fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status))
+}
+
+// LinkCheckStore is the storage of link check results.
+type LinkCheckStore interface {
+	// LinksToCheck returns at most limit short urls to web sites that
+	// have not been checked since before, or that failed their latest
+	// check more than linkCheckRetry ago.
+	LinksToCheck(before time.Time, limit int) ([]*Shorturl, error)
+	// SaveCheck stores the Check of the short url, setting its Failures
+	// to one more than in the previous check if it failed, and zero
+	// otherwise.
+	SaveCheck(s *Shorturl) error
+}
+
+// LinkChecker periodically checks that the targets of short urls still
+// respond. Links to the same domain are checked one at a time with a delay
+// in between, so that no site gets more than occasional requests.
+type LinkChecker struct {
+	Store LinkCheckStore
+	// Client makes the requests. Redirects are followed by the checker
+	// and not by the client. Nil uses a client with a timeout that only
+	// connects to public addresses.
+	Client *http.Client
+	// Concurrency is the number of domains checked at the same time.
+	Concurrency int
+	// DomainDelay is the time between requests to the same domain.
+	DomainDelay time.Duration
+	// Interval is how often each link is checked.
+	Interval time.Duration
+
+	mu sync.Mutex
+	// requested is the time of the latest request to each domain.
+	requested map[string]time.Time
+}
+
+// Run checks the links due for a check every interval. It never returns and
+// is meant to be run in its own goroutine. Full batches are checked one
+// after another until no more links are due, unless there are errors.
+// Otherwise links that failed to save would be checked again right away.
+func (lc *LinkChecker) Run(interval time.Duration) {
+	for {
+		links, err := lc.Store.LinksToCheck(time.Now().Add(-lc.Interval), linkCheckBatch)
+		if err == nil {
+			err = lc.CheckLinks(links)
+		}
+		if err != nil {
+			log.Printf("link check: %v", err)
+		}
+		if err != nil || len(links) < linkCheckBatch {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// CheckLinks checks the targets of the short urls and saves the results. It
+// returns the first error saving a result, after checking all of them.
+func (lc *LinkChecker) CheckLinks(links []*Shorturl) error {
+	byDomain := make(map[string][]*Shorturl)
+	for _, s := range links {
+		domain := s.TargetDomain()
+		byDomain[domain] = append(byDomain[domain], s)
+	}
+	concurrency := lc.Concurrency
+	if concurrency <= 0 {
+		concurrency = linkCheckConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var saveErr error
+	for domain, domainLinks := range byDomain {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(domain string, domainLinks []*Shorturl) {
+			defer func() { <-sem; wg.Done() }()
+			for _, s := range domainLinks {
+				lc.waitDomain(domain)
+				s.Check = lc.check(s.URL)
+				if err := lc.Store.SaveCheck(s); err != nil {
+					errOnce.Do(func() { saveErr = fmt.Errorf("saving %s: %v", s.UID(), err) })
+				}
+			}
+		}(domain, domainLinks)
+	}
+	wg.Wait()
+	return saveErr
+}
+
+// waitDomain waits until DomainDelay has passed since the previous request
+// to the domain, also when it was made while checking an earlier batch.
+func (lc *LinkChecker) waitDomain(domain string) {
+	lc.mu.Lock()
+	now := time.Now()
+	for d, t := range lc.requested {
+		// Domains that can be requested again right away are forgotten.
+		if now.Sub(t) >= lc.DomainDelay {
+			delete(lc.requested, d)
+		}
+	}
+	if lc.requested == nil {
+		lc.requested = make(map[string]time.Time)
+	}
+	next := now
+	if t, ok := lc.requested[domain]; ok {
+		next = t.Add(lc.DomainDelay)
+	}
+	lc.requested[domain] = next
+	lc.mu.Unlock()
+	time.Sleep(next.Sub(now))
+}
+
+// check requests the target, following redirects.
+func (lc *LinkChecker) check(target string) *LinkCheck {
+	client := lc.Client
+	if client == nil {
+		client = &http.Client{Timeout: linkCheckTimeout, Transport: publicTransport}
+	}
+	return followRedirects(context.Background(), client, target, linkCheckMaxHops)
+}
+
+// followRedirects requests the target and follows at most maxHops redirects.
+// Redirects are followed here and not by the client, so that the chain is
+// recorded. The requests are made with ctx.
+func followRedirects(ctx context.Context, client *http.Client, target string, maxHops int) *LinkCheck {
+	cl := *client
+	cl.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	c := &LinkCheck{Checked: time.Now()}
+	seen := map[string]bool{target: true}
+	for {
+		resp, err := linkCheckRequest(ctx, &cl, target)
+		if err != nil {
+			c.Error = err.Error()
+			return c
+		}
+		c.Status = resp.StatusCode
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			return c
+		}
+		if len(c.Redirects) >= maxHops {
+			c.Error = errTooManyRedirects
+			return c
+		}
+		next, err := resp.Request.URL.Parse(location)
+		if err != nil {
+			c.Error = "invalid redirect: " + err.Error()
+			return c
+		}
+		target = next.String()
+		c.Redirects = append(c.Redirects, target)
+		if seen[target] {
+			c.Error = errRedirectLoop
+			return c
+		}
+		seen[target] = true
+	}
+}
+
+// linkCheckRequest requests the target with HEAD, falling back to GET for
+// servers that do not support HEAD. The response body is discarded.
+func linkCheckRequest(ctx context.Context, client *http.Client, target string) (*http.Response, error) {
+	var resp *http.Response
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", linkCheckUserAgent)
+		resp, err = client.Do(req)
+		if err != nil {
+			if uerr, ok := err.(*url.Error); ok {
+				err = uerr.Err
+			}
+			return nil, err
+		}
+		io.CopyN(ioutil.Discard, resp.Body, 64*1024)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	return resp, nil
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// WriteDeadLinkReport writes a table of the short urls with dead targets.
+func WriteDeadLinkReport(w io.Writer, links []*Shorturl) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "UID\tCHECKED\tRESULT\tHITS\tURL")
+	for _, s := range links {
+		if !s.Check.Dead() {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n",
+			s.UID(), s.Check.Checked.Format("2006-01-02"), s.Check.Summary(), s.Hits, s.URL)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,164 @@
+package shorturl
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryCheckStore struct {
+	mu    sync.Mutex
+	saved map[int64]*LinkCheck
+	err   error
+}
+
+func (m *memoryCheckStore) LinksToCheck(before time.Time, limit int) ([]*Shorturl, error) {
+	return nil, nil
+}
+
+func (m *memoryCheckStore) SaveCheck(s *Shorturl) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.saved[s.ID] = s.Check
+	return nil
+}
+
+// linkCheckTests are the expected results of checking the links in
+// TestLinkChecker, by id.
+var linkCheckTests = []struct {
+	id        int64
+	status    int
+	redirects int
+	dead      bool
+}{
+	{1, http.StatusOK, 0, false},
+	{2, http.StatusGone, 0, true},
+	{3, http.StatusOK, 1, false},
+	// Failing once is not enough to be dead.
+	{4, http.StatusFound, 2, false},
+	{5, http.StatusOK, 0, false},
+}
+
+func TestLinkChecker(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/ok":
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/moved":
+			http.Redirect(w, req, "/ok", http.StatusMovedPermanently)
+		case "/loop":
+			http.Redirect(w, req, "/loop2", http.StatusFound)
+		case "/loop2":
+			http.Redirect(w, req, "/loop", http.StatusFound)
+		case "/nohead":
+			if req.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer ts.Close()
+
+	links := []*Shorturl{
+		{ID: 1, URL: ts.URL + "/ok"},
+		{ID: 2, URL: ts.URL + "/gone"},
+		{ID: 3, URL: ts.URL + "/moved"},
+		{ID: 4, URL: ts.URL + "/loop"},
+		{ID: 5, URL: ts.URL + "/nohead"},
+	}
+	store := &memoryCheckStore{saved: make(map[int64]*LinkCheck)}
+	delay := 10 * time.Millisecond
+	lc := &LinkChecker{Store: store, Client: ts.Client(), DomainDelay: delay}
+	start := time.Now()
+	if err := lc.CheckLinks(links); err != nil {
+		t.Fatal(err)
+	}
+	// All links are to the same domain, so they are checked one at a time.
+	if elapsed, min := time.Since(start), time.Duration(len(links)-1)*delay; elapsed < min {
+		t.Errorf("checks took %v, want at least %v", elapsed, min)
+	}
+	// The delay also applies between batches.
+	start = time.Now()
+	lc.CheckLinks(links[:1])
+	if elapsed := time.Since(start); elapsed < delay/2 {
+		t.Errorf("check after previous batch took %v, want a delay of %v", elapsed, delay)
+	}
+
+	for _, c := range linkCheckTests {
+		check := store.saved[c.id]
+		if check == nil {
+			t.Errorf("link %d not checked", c.id)
+			continue
+		}
+		if check.Status != c.status || len(check.Redirects) != c.redirects || check.Dead() != c.dead {
+			t.Errorf("link %d: status %d, redirects %q, dead %v; want %d, %d redirects, dead %v",
+				c.id, check.Status, check.Redirects, check.Dead(), c.status, c.redirects, c.dead)
+		}
+	}
+
+	var report bytes.Buffer
+	if err := WriteDeadLinkReport(&report, links); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "410 Gone") {
+		t.Errorf("report does not contain 410 Gone:\n%s", report.String())
+	}
+	for _, link := range []string{"/ok", "/loop"} {
+		if strings.Contains(report.String(), link) {
+			t.Errorf("report contains %s, which is not dead:\n%s", link, report.String())
+		}
+	}
+}
+
+var linkCheckDeadTests = []struct {
+	check LinkCheck
+	dead  bool
+}{
+	{LinkCheck{Status: http.StatusOK}, false},
+	{LinkCheck{Status: http.StatusNotFound}, true},
+	{LinkCheck{Status: http.StatusGone}, true},
+	{LinkCheck{Status: http.StatusForbidden}, false},
+	{LinkCheck{Status: http.StatusServiceUnavailable, Failures: 1}, false},
+	{LinkCheck{Status: http.StatusServiceUnavailable, Failures: linkCheckDeadAfter}, true},
+	{LinkCheck{Error: "i/o timeout", Failures: linkCheckDeadAfter - 1}, false},
+	{LinkCheck{Error: "i/o timeout", Failures: linkCheckDeadAfter}, true},
+}
+
+func TestLinkCheckDead(t *testing.T) {
+	for _, c := range linkCheckDeadTests {
+		if got := c.check.Dead(); got != c.dead {
+			t.Errorf("Dead() of %+v = %v, want %v", c.check, got, c.dead)
+		}
+	}
+}
+
+func TestCheckLinksSaveError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	store := &memoryCheckStore{err: errors.New("database is down")}
+	lc := &LinkChecker{Store: store, Client: ts.Client()}
+	err := lc.CheckLinks([]*Shorturl{{ID: 1, URL: ts.URL}, {ID: 2, URL: ts.URL + "/x"}})
+	if err == nil || !strings.Contains(err.Error(), "database is down") {
+		t.Errorf("CheckLinks error = %v, want save error", err)
+	}
+}
+
+func TestLinkCheckUnreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	target := ts.URL
+	ts.Close()
+	c := (&LinkChecker{Client: ts.Client()}).check(target)
+	if c.Error == "" || !c.Failed() {
+		t.Errorf("check of closed server = %+v, want error", c)
+	}
+}
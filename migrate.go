@@ -0,0 +1,311 @@
+package shorturl
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joneskoo/shorturl-go/assets"
+	"github.com/lib/pq"
+)
+
+// Migration errors
+var (
+	// ErrSchemaBehind is returned by CheckSchema when migrations are
+	// pending.
+	ErrSchemaBehind = errors.New("database schema is behind, run migrate up")
+	// ErrInitialMigration is returned by MigrateDown when reverting would
+	// drop the short url table, unless forced.
+	ErrInitialMigration = errors.New("reverting the initial migration drops all short urls, force to proceed")
+)
+
+// SQL for migrations
+const (
+	sqlMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied timestamp with time zone DEFAULT now() NOT NULL)`
+	// sqlMigrationLock keeps two migrations from running at the same time.
+	sqlMigrationLock    = "SELECT pg_advisory_xact_lock(4242001)"
+	sqlMigrationsExist  = "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	sqlMigrationsList   = "SELECT version, applied FROM schema_migrations ORDER BY version"
+	sqlMigrationVersion = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+	sqlMigrationApplied = "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)"
+	sqlMigrationUndone  = "DELETE FROM schema_migrations WHERE version = $1"
+
+	sqlNoCanonical  = "SELECT id, url FROM shorturl WHERE canonical IS NULL AND url IS NOT NULL AND NOT disabled"
+	sqlSetCanonical = `UPDATE shorturl SET canonical = c.canonical
+		FROM unnest($1::integer[], $2::text[]) AS c(id, canonical) WHERE shorturl.id = c.id`
+)
+
+// migrationSteps are changes made in Go as part of migrating up to the
+// version, before the statements of the migration are run.
+var migrationSteps = map[int]func(tx *sql.Tx) error{
+	10: canonicalizeURLs,
+}
+
+// Migration is a versioned change to the database schema. The migrations are
+// embedded in assets as migrations/NNNN_name.up.sql and the matching
+// .down.sql that reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and the time it was applied, or nil if it
+// has not been applied.
+type MigrationStatus struct {
+	Migration
+	Applied *time.Time
+}
+
+// Migrations returns the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(assets.FS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base := strings.TrimSuffix(name, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+		i := strings.IndexByte(base, '_')
+		if i < 0 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, err := strconv.Atoi(base[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: base[i+1:]}
+			byVersion[version] = m
+		}
+		data, err := fs.ReadFile(assets.FS, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+		if direction == ".up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	var list []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d %s is missing up or down", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// MigrationStatus lists the embedded migrations and when they were applied.
+// It does not change the database.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time)
+	var exists bool
+	if err := db.QueryRow(sqlMigrationsExist).Scan(&exists); err != nil || !exists {
+		return migrationStatus(migrations, applied), err
+	}
+	rows, err := db.Query(sqlMigrationsList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var t time.Time
+		if err := rows.Scan(&version, &t); err != nil {
+			return nil, err
+		}
+		applied[version] = t
+	}
+	return migrationStatus(migrations, applied), rows.Err()
+}
+
+func migrationStatus(migrations []Migration, applied map[int]time.Time) []MigrationStatus {
+	var list []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if t, ok := applied[m.Version]; ok {
+			status.Applied = &t
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+// MigrateUp applies the pending migrations in order, each in its own
+// transaction, and returns the applied migrations.
+func (db *DB) MigrateUp() ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range migrations {
+		ok, err := db.migrate(func(version int) (bool, error) {
+			return version < m.Version, nil
+		}, migrationSteps[m.Version], m.Up, sqlMigrationApplied, m.Version, m.Name)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the latest applied migration and returns it, or nil
+// if no migrations have been applied. The initial migration is only
+// reverted if forced, as it drops the short url table.
+func (db *DB) MigrateDown(force bool) (*Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	version, err := db.SchemaVersion()
+	if err != nil || version == 0 {
+		return nil, err
+	}
+	if version == 1 && !force {
+		return nil, ErrInitialMigration
+	}
+	for _, m := range migrations {
+		if m.Version != version {
+			continue
+		}
+		_, err := db.migrate(func(current int) (bool, error) {
+			if current != m.Version {
+				return false, errors.New("schema changed during migration")
+			}
+			return true, nil
+		}, nil, m.Down, sqlMigrationUndone, m.Version)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		return &m, nil
+	}
+	return nil, fmt.Errorf("schema version %d is not known", version)
+}
+
+// migrate runs step, if not nil, and the migration statements and records
+// the change with record in a transaction, if apply approves the current
+// schema version.
+func (db *DB) migrate(apply func(version int) (bool, error), step func(tx *sql.Tx) error,
+	statements, record string, args ...interface{}) (bool, error) {
+	if _, err := db.Exec(sqlMigrationsTable); err != nil {
+		return false, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(sqlMigrationLock); err != nil {
+		return false, err
+	}
+	var version int
+	if err := tx.QueryRow(sqlMigrationVersion).Scan(&version); err != nil {
+		return false, err
+	}
+	if ok, err := apply(version); !ok || err != nil {
+		return false, err
+	}
+	if step != nil {
+		if err := step(tx); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(statements); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SchemaVersion returns the version of the latest applied migration, or zero
+// if none have been applied. It does not change the database.
+func (db *DB) SchemaVersion() (int, error) {
+	var exists bool
+	if err := db.QueryRow(sqlMigrationsExist).Scan(&exists); err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRow(sqlMigrationVersion).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// CheckSchema returns ErrSchemaBehind if the database schema is older than
+// the latest embedded migration. It does not change the database, so the
+// server can run as a user without rights to change the schema.
+func (db *DB) CheckSchema() error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if len(migrations) > 0 && version < migrations[len(migrations)-1].Version {
+		return ErrSchemaBehind
+	}
+	return nil
+}
+
+// canonicalizeURLs sets the canonical url of the short urls added before
+// canonical urls were recorded, so that they are found when the same URL is
+// shortened again. Tracking parameters are kept, as they may have been
+// stripped only from the canonical urls of newer short urls. URLs that
+// cannot be canonicalized are left without.
+func canonicalizeURLs(tx *sql.Tx) error {
+	rows, err := tx.Query(sqlNoCanonical)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ids []int64
+	var canonicals []string
+	for rows.Next() {
+		var id int64
+		var rawurl string
+		if err := rows.Scan(&id, &rawurl); err != nil {
+			return err
+		}
+		if canonical, err := Canonicalize(rawurl, false); err == nil {
+			ids = append(ids, id)
+			canonicals = append(canonicals, canonical)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = tx.Exec(sqlSetCanonical, pq.Array(ids), pq.Array(canonicals))
+	return err
+}
@@ -0,0 +1,58 @@
+package shorturl
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d %s has version %d, want %d", i, m.Name, m.Version, i+1)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %d %s is empty", m.Version, m.Name)
+		}
+	}
+	if m := migrations[0]; m.Name != "initial" || !strings.Contains(m.Up, "CREATE TABLE IF NOT EXISTS shorturl") {
+		t.Errorf("first migration %d %s does not create the shorturl table", m.Version, m.Name)
+	}
+}
+
+func TestCanonicalizeURLs(t *testing.T) {
+	db := testDB(t)
+	var id int64
+	if err := db.QueryRow("INSERT INTO shorturl (url) VALUES ('HTTP://Example.com:80/a') RETURNING id").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := canonicalizeURLs(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := Canonicalize("http://example.com/a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Shorturl{URL: "http://example.com/a", Canonical: canonical}
+	if err := db.Add(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != id {
+		t.Errorf("Add of the URL of a short url from before canonical urls got id %d, want %d", s.ID, id)
+	}
+}
@@ -0,0 +1,98 @@
+package shorturl
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// oembedThumbnailSize is the size of the QR code offered as oEmbed thumbnail.
+const oembedThumbnailSize = 512
+
+// oembed is an oEmbed response of type "link", see https://oembed.com/.
+type oembed struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	Title           string `json:"title"`
+	Description     string `json:"description"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	ThumbnailURL    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+	CacheAge        int    `json:"cache_age"`
+}
+
+// Description summarizes where the short url goes, for link previews, as a
+// message to translate.
+func (p previewContext) Description() message {
+	domain, added := p.DisplayDomain(), p.Added.Format("2006-01-02")
+	if p.Warning != "" {
+		return msg("Short URL to %s, added %s. Warning: the target is reported as malicious.", domain, added)
+	}
+	if warning := p.DomainWarning(); warning != nil {
+		return msg("Short URL to %s, added %s. Warning: %s", domain, added, warning)
+	}
+	return msg("Short URL to %s, added %s.", domain, added)
+}
+
+// oembedCode is the short code in the path of a short url or its preview
+// page.
+func oembedCode(path string) string {
+	if strings.HasPrefix(path, "/p/") {
+		path = strings.TrimPrefix(path, "/p")
+	}
+	code, _ := splitCode(path)
+	return code
+}
+
+// oembedHandler describes a short url or its preview page for chat clients
+// that unfurl links. The url parameter is the short url to describe. Errors
+// are problem JSON.
+func oembedHandler(db Store, c Config) http.Handler {
+	h := c.handle(func(w http.ResponseWriter, req *http.Request) error {
+		if !c.allow(w, req, limitPreview) {
+			return nil
+		}
+		if f := req.FormValue("format"); f != "" && f != "json" {
+			return &Error{Status: http.StatusNotImplemented, Detail: "only json format is supported"}
+		}
+		u, err := url.Parse(req.FormValue("url"))
+		if err != nil || !strings.EqualFold(u.Host, host(req)) {
+			return &Error{Status: http.StatusNotFound, Detail: "url is not a short url of this service"}
+		}
+		s, err := db.Get(req.Context(), oembedCode(u.Path))
+		if err == nil && s.Disabled {
+			err = ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		reason, _ := c.Blocklist.Match(s.URL)
+		p := previewContext{Shorturl: s, Warning: reason}
+		base := baseURL(req)
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(oembed{
+			Version:         "1.0",
+			Type:            "link",
+			Title:           host(req) + "/" + s.UID(),
+			Description:     translate(requestLanguage(req), p.Description()),
+			ProviderName:    host(req) + " short URLs",
+			ProviderURL:     base + "/",
+			ThumbnailURL:    fmt.Sprintf("%s/p/%s.png?size=%d", base, s.UID(), oembedThumbnailSize),
+			ThumbnailWidth:  oembedThumbnailSize,
+			ThumbnailHeight: oembedThumbnailSize,
+			CacheAge:        86400,
+		})
+		if err != nil {
+			log.Printf("writing oembed response: %v", err)
+		}
+		return nil
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(w, withFormat(req, formatJSON))
+	})
+}
@@ -0,0 +1,21 @@
+package shorturl
+
+import "testing"
+
+var oembedCodeTests = []struct {
+	path string
+	code string
+}{
+	{"/p/za", "za"},
+	{"/za", "za"},
+	{"/p1", "p1"},
+	{"/p/za/", "za"},
+}
+
+func TestOembedCode(t *testing.T) {
+	for _, c := range oembedCodeTests {
+		if got := oembedCode(c.path); got != c.code {
+			t.Errorf("oembedCode(%s) = %s, want %s", c.path, got, c.code)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package shorturl
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Passthrough is the per short url setting for passing extra path segments
+// and query parameters of the short url on to the target. The modes differ
+// in how parameters present in both the target and the request are merged.
+type Passthrough string
+
+// Passthrough modes
+const (
+	// PassthroughOff redirects to the target as is. Extra path segments
+	// are not found and query parameters are ignored.
+	PassthroughOff Passthrough = ""
+	// PassthroughKeepTarget adds request parameters that the target does
+	// not set. Parameters set in the target are kept.
+	PassthroughKeepTarget Passthrough = "target"
+	// PassthroughOverride lets request parameters replace target
+	// parameters of the same name.
+	PassthroughOverride Passthrough = "request"
+	// PassthroughAppend adds all request parameters after the target
+	// parameters, even when the same name is used by both.
+	PassthroughAppend Passthrough = "append"
+)
+
+// splitCode splits the request path to short code and the extra path after
+// it, e.g. "/abc/extra" to "abc" and "/extra".
+func splitCode(path string) (code, extra string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i:]
+	}
+	return path, ""
+}
+
+// Target returns the URL to redirect to for a request with extra escaped
+// path and raw query, merged into the short url target according to its
+// passthrough mode.
+func (s *Shorturl) Target(extraPath, rawQuery string) (string, error) {
+	if s.Passthrough == PassthroughOff || (extraPath == "" && rawQuery == "") {
+		return s.URL, nil
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", err
+	}
+	if extraPath != "" {
+		p := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + strings.TrimPrefix(extraPath, "/")
+		if u.Path, err = url.PathUnescape(p); err != nil {
+			return "", err
+		}
+		u.RawPath = p
+	}
+	u.RawQuery = mergeQuery(u.RawQuery, rawQuery, s.Passthrough)
+	return u.String(), nil
+}
+
+// mergeQuery merges the raw request query into the raw target query. The
+// order and encoding of parameters is preserved.
+func mergeQuery(target, request string, mode Passthrough) string {
+	if request == "" {
+		return target
+	}
+	if target == "" {
+		return request
+	}
+	targetParams := strings.Split(target, "&")
+	requestParams := strings.Split(request, "&")
+	var merged []string
+	switch mode {
+	case PassthroughKeepTarget:
+		names := paramNames(targetParams)
+		merged = targetParams
+		for _, p := range requestParams {
+			if !names[paramName(p)] {
+				merged = append(merged, p)
+			}
+		}
+	case PassthroughOverride:
+		names := paramNames(requestParams)
+		for _, p := range targetParams {
+			if !names[paramName(p)] {
+				merged = append(merged, p)
+			}
+		}
+		merged = append(merged, requestParams...)
+	default:
+		merged = append(targetParams, requestParams...)
+	}
+	return strings.Join(merged, "&")
+}
+
+func paramNames(params []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, p := range params {
+		names[paramName(p)] = true
+	}
+	return names
+}
+
+func paramName(param string) string {
+	if i := strings.IndexByte(param, '='); i >= 0 {
+		param = param[:i]
+	}
+	if name, err := url.QueryUnescape(param); err == nil {
+		return name
+	}
+	return param
+}
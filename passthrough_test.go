@@ -0,0 +1,56 @@
+package shorturl
+
+import "testing"
+
+var passthroughTests = []struct {
+	target string
+	mode   Passthrough
+	path   string
+	query  string
+	want   string
+}{
+	{"https://docs.example/", PassthroughOff, "", "utm=x", "https://docs.example/"},
+	{"https://docs.example/", PassthroughAppend, "", "", "https://docs.example/"},
+	{"https://docs.example/", PassthroughAppend, "/guide/intro", "", "https://docs.example/guide/intro"},
+	{"https://docs.example/v1", PassthroughAppend, "/guide/", "", "https://docs.example/v1/guide/"},
+	{"https://docs.example/", PassthroughAppend, "/a%20b", "", "https://docs.example/a%20b"},
+	{"https://docs.example/#top", PassthroughAppend, "/guide", "", "https://docs.example/guide#top"},
+	{"https://docs.example/", PassthroughAppend, "", "utm=x", "https://docs.example/?utm=x"},
+	{"https://docs.example/?lang=en&v=1", PassthroughKeepTarget, "", "lang=fi&q=go", "https://docs.example/?lang=en&v=1&q=go"},
+	{"https://docs.example/?lang=en&v=1", PassthroughOverride, "", "lang=fi&q=go", "https://docs.example/?v=1&lang=fi&q=go"},
+	{"https://docs.example/?lang=en&v=1", PassthroughAppend, "", "lang=fi&q=go", "https://docs.example/?lang=en&v=1&lang=fi&q=go"},
+	{"https://docs.example/?a%5B%5D=1", PassthroughOverride, "", "a[]=2", "https://docs.example/?a[]=2"},
+}
+
+func TestTarget(t *testing.T) {
+	for _, c := range passthroughTests {
+		s := &Shorturl{URL: c.target, Passthrough: c.mode}
+		got, err := s.Target(c.path, c.query)
+		if err != nil {
+			t.Errorf("Target(%s, %s) for %s failed: %v", c.path, c.query, c.target, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Target(%s, %s) for %s (%q) = %s, want %s", c.path, c.query, c.target, c.mode, got, c.want)
+		}
+	}
+}
+
+var splitCodeTests = []struct {
+	path  string
+	code  string
+	extra string
+}{
+	{"/abc", "abc", ""},
+	{"/abc/", "abc", "/"},
+	{"/abc/extra/page", "abc", "/extra/page"},
+	{"abc", "abc", ""},
+}
+
+func TestSplitCode(t *testing.T) {
+	for _, c := range splitCodeTests {
+		if code, extra := splitCode(c.path); code != c.code || extra != c.extra {
+			t.Errorf("splitCode(%s) = %s, %s, want %s, %s", c.path, code, extra, c.code, c.extra)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package shorturl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR code image size limits in pixels
+const (
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 1024
+)
+
+// qrLevels are the error correction levels accepted in the "level" query
+// parameter. Higher levels survive more damage but need a larger code.
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// qrFormats are the image formats by file extension.
+var qrFormats = map[string]string{
+	".png": "image/png",
+	".svg": "image/svg+xml",
+}
+
+// serveQR responds with a QR code image of the full short url in the format
+// of ext. The "size" query parameter sets the image size in pixels and
+// "level" the error correction level.
+func serveQR(w http.ResponseWriter, req *http.Request, s *Shorturl, ext string) error {
+	size := qrDefaultSize
+	if v := req.FormValue("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			return &Error{Status: http.StatusBadRequest, Detail: fmt.Sprintf("size must be %d to %d", qrMinSize, qrMaxSize)}
+		}
+		size = n
+	}
+	levelName := strings.ToUpper(req.FormValue("level"))
+	if levelName == "" {
+		levelName = "M"
+	}
+	level, ok := qrLevels[levelName]
+	if !ok {
+		return &Error{Status: http.StatusBadRequest, Detail: "level must be one of L, M, Q or H"}
+	}
+
+	content := baseURL(req) + "/" + s.UID()
+	// The image only depends on these, so they make a strong ETag and
+	// the image need not be rendered when the client has it already.
+	sum := sha256.Sum256([]byte(strings.Join([]string{content, ext, levelName, strconv.Itoa(size)}, "\x00")))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	q, err := qrcode.New(content, level)
+	if err != nil {
+		return err
+	}
+	var image []byte
+	switch ext {
+	case ".png":
+		image, err = q.PNG(size)
+	case ".svg":
+		image = qrSVG(q.Bitmap(), size)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", qrFormats[ext])
+	http.ServeContent(w, req, s.UID()+ext, time.Time{}, bytes.NewReader(image))
+	return nil
+}
+
+// qrSVG renders the QR code bitmap as SVG, one unit per module. The bitmap
+// includes the quiet zone around the code.
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var b bytes.Buffer
+	n := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
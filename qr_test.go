@@ -0,0 +1,64 @@
+package shorturl
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var serveQRTests = []struct {
+	ext         string
+	contentType string
+	prefix      string
+}{
+	{".png", "image/png", "\x89PNG"},
+	{".svg", "image/svg+xml", "<svg"},
+}
+
+func TestServeQR(t *testing.T) {
+	s := &Shorturl{ID: 1270}
+	for _, c := range serveQRTests {
+		req := httptest.NewRequest("GET", "http://yx.fi/p/za"+c.ext+"?size=128&level=h", nil)
+		w := httptest.NewRecorder()
+		serveQR(w, req, s, c.ext)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", c.ext, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != c.contentType {
+			t.Errorf("%s: Content-Type = %s, want %s", c.ext, got, c.contentType)
+		}
+		if !bytes.HasPrefix(w.Body.Bytes(), []byte(c.prefix)) {
+			t.Errorf("%s: body does not start with %q", c.ext, c.prefix)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: no ETag", c.ext)
+		}
+
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		serveQR(w, req, s, c.ext)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: status with matching ETag = %d, want %d", c.ext, w.Code, http.StatusNotModified)
+		}
+	}
+}
+
+func TestServeQRBadParams(t *testing.T) {
+	for _, query := range []string{"size=10", "size=5000", "size=x", "level=Z"} {
+		req := httptest.NewRequest("GET", "http://yx.fi/p/za.png?"+query, nil)
+		err := serveQR(httptest.NewRecorder(), req, &Shorturl{ID: 1270}, ".png")
+		if status := errorResponse(err).Status; err == nil || status != http.StatusBadRequest {
+			t.Errorf("%s: error %v, want status %d", query, err, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestQRSVG(t *testing.T) {
+	svg := string(qrSVG([][]bool{{true, false}, {false, true}}, 100))
+	if !strings.Contains(svg, `viewBox="0 0 2 2"`) || !strings.Contains(svg, "M0 0h1v1h-1zM1 1h1v1h-1z") {
+		t.Errorf("unexpected SVG: %s", svg)
+	}
+}
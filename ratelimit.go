@@ -0,0 +1,260 @@
+package shorturl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket rate limit. Up to Burst requests are allowed at
+// once, and the bucket refills at Rate requests per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// String formats the limit as "rate:burst", the format accepted by Set.
+func (l *Limit) String() string {
+	return fmt.Sprintf("%g:%d", l.Rate, l.Burst)
+}
+
+// Set parses a limit in "rate:burst" format, e.g. "0.5:10" for one request
+// every two seconds with bursts of up to ten requests.
+func (l *Limit) Set(s string) error {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid limit %q, want rate:burst", s)
+	}
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate <= 0 {
+		return fmt.Errorf("invalid rate in limit %q", s)
+	}
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil || burst < 1 {
+		return fmt.Errorf("invalid burst in limit %q", s)
+	}
+	l.Rate, l.Burst = rate, burst
+	return nil
+}
+
+// Limit classes, each with its own bucket per client.
+const (
+	limitRedirect = "redirect"
+	limitPreview  = "preview"
+	limitNotFound = "notfound"
+	limitCreate   = "create"
+)
+
+// RateLimits configures the limits for each class of request.
+type RateLimits struct {
+	// Redirect limits short url redirects.
+	Redirect Limit
+	// Preview limits preview page views.
+	Preview Limit
+	// NotFound limits lookups of short urls that do not exist. It is
+	// stricter than the others to stop enumeration of sequential ids.
+	NotFound Limit
+	// Create limits adding new short urls.
+	Create Limit
+}
+
+// DefaultRateLimits are the limits used unless configured otherwise.
+var DefaultRateLimits = RateLimits{
+	Redirect: Limit{Rate: 10, Burst: 50},
+	Preview:  Limit{Rate: 2, Burst: 20},
+	NotFound: Limit{Rate: 0.2, Burst: 10},
+	Create:   Limit{Rate: 0.1, Burst: 5},
+}
+
+// RateLimitBackend stores the token buckets. Take removes a token from the
+// bucket identified by key, or reports how long until one is available.
+type RateLimitBackend interface {
+	Take(ctx context.Context, key string, l Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// RateLimiter limits requests per client IP address.
+type RateLimiter struct {
+	Limits  RateLimits
+	Backend RateLimitBackend
+	// TrustedProxies are the networks of reverse proxies whose
+	// X-Forwarded-For header is trusted to carry the client address.
+	//
+	// Deprecated: Set Config.TrustedProxies, which applies to all uses of
+	// the client address. These are used when it is not set.
+	TrustedProxies []*net.IPNet
+}
+
+func (rl *RateLimiter) limit(class string) Limit {
+	switch class {
+	case limitRedirect:
+		return rl.Limits.Redirect
+	case limitPreview:
+		return rl.Limits.Preview
+	case limitNotFound:
+		return rl.Limits.NotFound
+	case limitCreate:
+		return rl.Limits.Create
+	}
+	return Limit{}
+}
+
+// allow takes a token from the client's bucket for the limit class. If the
+// limit is exceeded, it responds with 429 Too Many Requests and returns
+// false. Without a RateLimiter everything is allowed.
+func (c Config) allow(w http.ResponseWriter, req *http.Request, class string) bool {
+	if c.RateLimiter == nil {
+		return true
+	}
+	l := c.RateLimiter.limit(class)
+	if l.Rate <= 0 {
+		return true
+	}
+	ok, retryAfter, err := c.RateLimiter.Backend.Take(req.Context(), class+":"+c.clientKey(req), l)
+	if err != nil {
+		// Failing open: a broken limiter must not take the service down.
+		log.Printf("rate limiter: %v", err)
+		return true
+	}
+	if !ok {
+		(&Error{Status: http.StatusTooManyRequests, RetryAfter: retryAfter}).ServeHTTP(w, req)
+	}
+	return ok
+}
+
+// clientKey identifies the client by IP address. IPv6 clients are grouped
+// by /64 network since a single host usually has the whole prefix. Clients
+// without a valid address are identified by the remote address as is.
+func (c Config) clientKey(req *http.Request) string {
+	ip := c.clientIP(req)
+	if ip == nil {
+		return req.RemoteAddr
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return ip.String()
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is walked from
+// the right, skipping trusted proxies, so that clients cannot spoof their
+// address by sending the header themselves.
+func (c Config) clientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !c.trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !c.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (c Config) trustedProxy(ip net.IP) bool {
+	proxies := c.TrustedProxies
+	if proxies == nil && c.RateLimiter != nil {
+		proxies = c.RateLimiter.TrustedProxies
+	}
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitPruneInterval is how often buckets that have refilled completely
+// are forgotten.
+const rateLimitPruneInterval = time.Minute
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket for the time passed since last update and then
+// removes a token if one is available.
+func (b *bucket) take(l Limit, now time.Time) (bool, time.Duration) {
+	if b.updated.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.updated).Seconds()*l.Rate)
+	}
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// full reports whether the bucket would be full at now, in which case
+// forgetting it makes no difference.
+func (b *bucket) full(l Limit, now time.Time) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*l.Rate >= float64(l.Burst)
+}
+
+// MemoryRateLimitBackend keeps token buckets in process memory. It is
+// suitable when a single server process handles all traffic.
+type MemoryRateLimitBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	pruned  time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	limit Limit
+}
+
+// NewMemoryRateLimitBackend creates an empty in-memory backend.
+func NewMemoryRateLimitBackend() *MemoryRateLimitBackend {
+	return &MemoryRateLimitBackend{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take implements RateLimitBackend.
+func (m *MemoryRateLimitBackend) Take(ctx context.Context, key string, l Limit) (bool, time.Duration, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.pruned) > rateLimitPruneInterval {
+		m.prune(now)
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		m.buckets[key] = b
+	}
+	b.limit = l
+	ok, retryAfter := b.take(l, now)
+	return ok, retryAfter, nil
+}
+
+// prune forgets buckets that have refilled completely.
+func (m *MemoryRateLimitBackend) prune(now time.Time) {
+	for key, b := range m.buckets {
+		if b.full(b.limit, now) {
+			delete(m.buckets, key)
+		}
+	}
+	m.pruned = now
+}
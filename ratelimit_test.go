@@ -0,0 +1,132 @@
+package shorturl
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// bucketTakeTests are consecutive takes from a bucket with rate 1 and burst 2.
+var bucketTakeTests = []struct {
+	after time.Duration
+	want  bool
+}{
+	{0, true},
+	{0, true},
+	{0, false},
+	{500 * time.Millisecond, false},
+	{500 * time.Millisecond, true},
+	{10 * time.Second, true},
+	{0, true},
+	{0, false},
+}
+
+func TestBucketTake(t *testing.T) {
+	l := Limit{Rate: 1, Burst: 2}
+	now := time.Now()
+	var b bucket
+	for i, c := range bucketTakeTests {
+		now = now.Add(c.after)
+		if got, _ := b.take(l, now); got != c.want {
+			t.Errorf("step %d: take() = %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestLimitSet(t *testing.T) {
+	var l Limit
+	if err := l.Set("0.5:10"); err != nil || l.Rate != 0.5 || l.Burst != 10 {
+		t.Errorf("Set(0.5:10) = %v, %+v", err, l)
+	}
+	for _, s := range []string{"", "1", "a:1", "1:b", "0:1", "1:0"} {
+		if err := l.Set(s); err == nil {
+			t.Errorf("Set(%q) did not fail", s)
+		}
+	}
+}
+
+var clientIPTests = []struct {
+	remote string
+	xff    string
+	want   string
+}{
+	{"192.0.2.1:1234", "", "192.0.2.1"},
+	{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+	{"127.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+	{"127.0.0.1:1234", "198.51.100.1, 198.51.100.2", "198.51.100.2"},
+	{"127.0.0.1:1234", "198.51.100.1, 127.0.0.2", "198.51.100.1"},
+	{"127.0.0.1:1234", "garbage", "127.0.0.1"},
+}
+
+func TestClientIP(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	c := Config{TrustedProxies: []*net.IPNet{loopback}}
+	for _, tc := range clientIPTests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := c.clientIP(req).String(); got != tc.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tc.remote, tc.xff, got, tc.want)
+		}
+	}
+}
+
+var clientKeyTests = []struct {
+	remote string
+	want   string
+}{
+	{"192.0.2.1:1234", "192.0.2.1"},
+	{"[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2::"},
+	{"@", "@"},
+}
+
+func TestClientKey(t *testing.T) {
+	var c Config
+	for _, tc := range clientKeyTests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if got := c.clientKey(req); got != tc.want {
+			t.Errorf("clientKey(%s) = %s, want %s", tc.remote, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimiterTrustedProxies(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	c := Config{RateLimiter: &RateLimiter{TrustedProxies: []*net.IPNet{loopback}}}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := c.clientIP(req).String(); got != "198.51.100.1" {
+		t.Errorf("clientIP = %s, want 198.51.100.1", got)
+	}
+}
+
+func TestRateLimiterResponds429(t *testing.T) {
+	c := Config{RateLimiter: &RateLimiter{
+		Limits:  RateLimits{Preview: Limit{Rate: 0.001, Burst: 1}},
+		Backend: NewMemoryRateLimitBackend(),
+	}}
+	req := httptest.NewRequest("GET", "/p/a", nil)
+	w := httptest.NewRecorder()
+	if !c.allow(w, req, limitPreview) {
+		t.Fatal("first request was limited")
+	}
+	w = httptest.NewRecorder()
+	if c.allow(w, req, limitPreview) {
+		t.Fatal("second request was allowed")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header not set")
+	}
+	if !c.allow(httptest.NewRecorder(), req, limitRedirect) {
+		t.Error("unconfigured limit class was limited")
+	}
+}
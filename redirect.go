@@ -0,0 +1,57 @@
+package shorturl
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// redirectStatuses are the status codes that may be used for redirects.
+// Permanent redirects may be cached by browsers and CDNs.
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             false,
+	http.StatusTemporaryRedirect: false,
+	http.StatusPermanentRedirect: true,
+}
+
+// defaultPermanentMaxAge is how long permanent redirects are cached unless
+// configured otherwise.
+const defaultPermanentMaxAge = 30 * 24 * time.Hour
+
+// ValidRedirectStatus reports whether the status code can be used for
+// redirects.
+func ValidRedirectStatus(status int) bool {
+	_, ok := redirectStatuses[status]
+	return ok
+}
+
+// redirect redirects to the target with the status code, or the configured
+// default if status is not a redirect status code. Permanent redirects
+// are cached for PermanentMaxAge, temporary ones are not cached at all, so
+// that changing the target takes effect immediately.
+//
+// Cached redirects are followed by the browser without asking the server, so
+// they are not counted as hits. They do not vary by cookie, so that CDNs can
+// serve them to everyone; a browser that has a redirect cached keeps following
+// it after the always preview preference is set.
+func (c Config) redirect(w http.ResponseWriter, req *http.Request, status int, target string) {
+	if !ValidRedirectStatus(status) {
+		status = c.RedirectStatus
+	}
+	if !ValidRedirectStatus(status) {
+		status = http.StatusFound
+	}
+	if redirectStatuses[status] {
+		maxAge := c.PermanentMaxAge
+		if maxAge <= 0 {
+			maxAge = defaultPermanentMaxAge
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+		w.Header().Set("Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Expires", time.Now().UTC().Format(http.TimeFormat))
+	}
+	http.Redirect(w, req, target, status)
+}
@@ -0,0 +1,52 @@
+package shorturl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var redirectStatusTests = []struct {
+	name        string
+	defaultCode int
+	linkCode    int
+	code        int
+	cache       string
+	cached      bool
+}{
+	{"default", 0, 0, http.StatusFound, "no-cache", false},
+	{"deployment permanent", http.StatusPermanentRedirect, 0, http.StatusPermanentRedirect, "public, max-age=3600", true},
+	{"link overrides", http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusTemporaryRedirect, "no-cache", false},
+	{"link permanent", http.StatusFound, http.StatusMovedPermanently, http.StatusMovedPermanently, "public, max-age=3600", true},
+	{"invalid link status", http.StatusFound, http.StatusOK, http.StatusFound, "no-cache", false},
+}
+
+func TestRedirectStatus(t *testing.T) {
+	for _, c := range redirectStatusTests {
+		config := Config{RedirectStatus: c.defaultCode, PermanentMaxAge: time.Hour}
+		s := &Shorturl{ID: 10, URL: "https://example.com/", RedirectStatus: c.linkCode}
+		w := httptest.NewRecorder()
+		config.redirect(w, httptest.NewRequest("GET", "http://yx.fi/a", nil), s.RedirectStatus, s.URL)
+		if w.Code != c.code {
+			t.Errorf("%s: status = %d, want %d", c.name, w.Code, c.code)
+		}
+		if got := w.Header().Get("Location"); got != s.URL {
+			t.Errorf("%s: Location = %q, want %q", c.name, got, s.URL)
+		}
+		if got := w.Header().Get("Cache-Control"); got != c.cache {
+			t.Errorf("%s: Cache-Control = %q, want %q", c.name, got, c.cache)
+		}
+		if got := w.Header().Get("Vary"); got != "" {
+			t.Errorf("%s: Vary = %q, want none so that CDNs can cache redirects", c.name, got)
+		}
+		expires, err := http.ParseTime(w.Header().Get("Expires"))
+		if err != nil {
+			t.Errorf("%s: Expires: %v", c.name, err)
+			continue
+		}
+		if got := expires.After(time.Now().Add(time.Minute)); got != c.cached {
+			t.Errorf("%s: Expires = %v, in future = %v, want %v", c.name, expires, got, c.cached)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package shorturl
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// replicaRetry is how long a failed replica is left unused before trying it
+// again.
+const replicaRetry = 30 * time.Second
+
+type primaryKey struct{}
+
+// readPrimary makes the reads for the context go to the primary database,
+// so that they see the writes made just before, which replicas may not have
+// received yet.
+func readPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// AddReplica adds a read-only replica of the database for reads made for
+// redirects and previews.
+func (db *DB) AddReplica(dataSourceName string) error {
+	r, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return err
+	}
+	db.replicas = append(db.replicas, &pool{DB: r})
+	return nil
+}
+
+// SetConnLimits configures the connection pools of the primary and the
+// replicas.
+func (db *DB) SetConnLimits(maxOpen, maxIdle int, lifetime time.Duration) {
+	for _, p := range append([]*pool{db.pool}, db.replicas...) {
+		p.SetMaxOpenConns(maxOpen)
+		p.SetMaxIdleConns(maxIdle)
+		p.SetConnMaxLifetime(lifetime)
+	}
+}
+
+// read runs the query on a replica, falling back to the primary if the
+// replica fails. Each attempt has its own QueryTimeout.
+func (db *DB) read(ctx context.Context, query func(context.Context, *pool) error) error {
+	if r := db.replica(ctx); r != nil {
+		qctx, cancel := db.context(ctx)
+		err := queryError(qctx, query(qctx, r))
+		cancel()
+		if err == nil || err == ErrNotFound || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("reading from replica failed, using primary: %v", err)
+		r.mu.Lock()
+		r.failed = time.Now()
+		r.mu.Unlock()
+	}
+	qctx, cancel := db.context(ctx)
+	defer cancel()
+	return queryError(qctx, query(qctx, db.pool))
+}
+
+// replica picks the next working replica in turn, or nil if reads should
+// go to the primary.
+func (db *DB) replica(ctx context.Context) *pool {
+	if len(db.replicas) == 0 || ctx.Value(primaryKey{}) != nil {
+		return nil
+	}
+	start := atomic.AddUint32(&db.next, 1)
+	for i := range db.replicas {
+		r := db.replicas[(int(start)+i)%len(db.replicas)]
+		r.mu.Lock()
+		failed := r.failed
+		r.mu.Unlock()
+		if time.Since(failed) > replicaRetry {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package shorturl
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReadReplicaFallback(t *testing.T) {
+	primary, replica := &pool{}, &pool{}
+	db := &DB{pool: primary, replicas: []*pool{replica}}
+	replicaErr := errors.New("connection refused")
+
+	var used []*pool
+	query := func(err error) func(context.Context, *pool) error {
+		return func(ctx context.Context, p *pool) error {
+			used = append(used, p)
+			if p == replica {
+				return err
+			}
+			return nil
+		}
+	}
+
+	if err := db.read(context.Background(), query(nil)); err != nil || len(used) != 1 || used[0] != replica {
+		t.Errorf("read used %v, err %v; want replica only", used, err)
+	}
+
+	used = nil
+	if err := db.read(context.Background(), query(ErrNotFound)); err != ErrNotFound || len(used) != 1 {
+		t.Errorf("not found on replica: used %v, err %v; want replica only", used, err)
+	}
+
+	used = nil
+	if err := db.read(context.Background(), query(replicaErr)); err != nil || len(used) != 2 || used[1] != primary {
+		t.Errorf("replica failure: used %v, err %v; want fallback to primary", used, err)
+	}
+
+	// The failed replica is not used again right away.
+	used = nil
+	if err := db.read(context.Background(), query(nil)); err != nil || len(used) != 1 || used[0] != primary {
+		t.Errorf("after failure: used %v, err %v; want primary", used, err)
+	}
+
+	replica.failed = replica.failed.Add(-2 * replicaRetry)
+	used = nil
+	if err := db.read(readPrimary(context.Background()), query(nil)); err != nil || len(used) != 1 || used[0] != primary {
+		t.Errorf("read your writes: used %v, err %v; want primary", used, err)
+	}
+}
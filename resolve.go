@@ -0,0 +1,131 @@
+package shorturl
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resolveTimeout limits the time resolving the redirect chain of a new short
+// url may take.
+const resolveTimeout = 10 * time.Second
+
+// nonPublicNetworks are the special purpose address ranges not recognized
+// by the methods of net.IP used in publicAddress.
+var nonPublicNetworks = mustParseNetworks(
+	"0.0.0.0/8",     // this network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"224.0.0.0/4",   // multicast
+	"240.0.0.0/4",   // reserved and broadcast
+	"64:ff9b::/96",  // NAT64 translation to IPv4
+)
+
+var errNonPublicAddress = errors.New("address is not public")
+
+// publicTransport is the transport for requesting targets. It only connects
+// to public addresses.
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return errNonPublicAddress
+			}
+			return nil
+		},
+	}).DialContext,
+	TLSHandshakeTimeout: 10 * time.Second,
+	MaxIdleConns:        10,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// publicAddress reports whether targets may be requested from the address,
+// so that the server cannot be used to probe the internal network.
+func publicAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		// IPv4-mapped IPv6 addresses are checked as IPv4 addresses.
+		ip = ip4
+	}
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// resolveTarget follows the redirects of a web target being shortened and
+// returns the final destination, or empty string if the target does not
+// redirect, could not be reached or redirects more than ResolveHops times.
+// The error is shown to the user when the target loops or leads back to
+// this service, translated. Resolving stops when the request is canceled.
+func (c Config) resolveTarget(req *http.Request, target string) (string, error) {
+	if c.ResolveHops <= 0 {
+		return "", nil
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", nil
+	}
+	self := strings.ToLower(host(req))
+	if isHost(target, self) {
+		return "", msg("The URL is already a short URL of %s", self)
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: resolveTimeout, Transport: publicTransport}
+	}
+	chain := followRedirects(req.Context(), client, target, c.ResolveHops)
+	for _, hop := range chain.Redirects {
+		if isHost(hop, self) {
+			return "", msg("The URL redirects back to %s", self)
+		}
+	}
+	if chain.Error == errRedirectLoop {
+		return "", msg("The URL redirects in a loop")
+	}
+	// The last of too many redirects is not the final destination.
+	if len(chain.Redirects) == 0 || chain.Error == errTooManyRedirects {
+		return "", nil
+	}
+	return chain.Redirects[len(chain.Redirects)-1], nil
+}
+
+// isHost reports whether the URL is on the host, given as in the Host header.
+func isHost(rawurl, host string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	h := strings.TrimSuffix(strings.ToLower(u.Host), ".")
+	if h == host {
+		return true
+	}
+	// Default ports are left out of either one.
+	return strings.TrimSuffix(strings.TrimSuffix(h, ":80"), ":443") ==
+		strings.TrimSuffix(strings.TrimSuffix(host, ":80"), ":443")
+}
@@ -0,0 +1,125 @@
+package shorturl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var resolveTargetTests = []struct {
+	target string
+	final  string
+	err    string
+}{
+	{"/final", "", ""},
+	{"/a", "/final", ""},
+	{"/self", "", "redirects back to yx.fi"},
+	{"/loop", "", "loop"},
+	{"https://yx.fi/za", "", "already a short URL"},
+	{"HTTP://YX.FI:80/za", "", "already a short URL"},
+	{"ftp://yx.fi/file", "", ""},
+}
+
+func TestResolveTarget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/a":
+			http.Redirect(w, req, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, req, "/final", http.StatusFound)
+		case "/self":
+			http.Redirect(w, req, "https://yx.fi/za", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, req, "/loop", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+	c := Config{ResolveHops: 5, Client: ts.Client()}
+	req := httptest.NewRequest("POST", "http://yx.fi/add/", nil)
+
+	// Paths are on the test server.
+	onServer := func(s string) string {
+		if strings.HasPrefix(s, "/") {
+			return ts.URL + s
+		}
+		return s
+	}
+	for _, tc := range resolveTargetTests {
+		target, want := onServer(tc.target), onServer(tc.final)
+		final, err := c.resolveTarget(req, target)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("resolveTarget(%q) error = %v, want %q", target, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || final != want {
+			t.Errorf("resolveTarget(%q) = %q, %v; want %q", target, final, err, want)
+		}
+	}
+
+	c.ResolveHops = 2
+	if final, err := c.resolveTarget(req, ts.URL+"/a"); err != nil || final != ts.URL+"/final" {
+		t.Errorf("resolveTarget with two hops = %q, %v; want %q", final, err, ts.URL+"/final")
+	}
+	// The last of too many redirects is not stored as the destination.
+	c.ResolveHops = 1
+	if final, err := c.resolveTarget(req, ts.URL+"/a"); err != nil || final != "" {
+		t.Errorf("resolveTarget with one hop = %q, %v; want none", final, err)
+	}
+
+	// Resolving stops when the request is canceled.
+	c.ResolveHops = 5
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if final, err := c.resolveTarget(req.WithContext(ctx), ts.URL+"/a"); err != nil || final != "" {
+		t.Errorf("resolveTarget of canceled request = %q, %v; want none", final, err)
+	}
+}
+
+var publicAddressTests = []struct {
+	ip     string
+	public bool
+}{
+	{"93.184.216.34", true},
+	{"2606:2800:220:1:248:1893:25c8:1946", true},
+	{"10.1.2.3", false},
+	{"172.16.0.1", false},
+	{"192.168.1.1", false},
+	{"127.0.0.1", false},
+	{"169.254.169.254", false},
+	{"0.0.0.0", false},
+	{"100.64.0.1", false},
+	{"192.0.0.8", false},
+	{"198.18.0.1", false},
+	{"224.0.0.1", false},
+	{"255.255.255.255", false},
+	{"::", false},
+	{"::1", false},
+	{"fd00::1", false},
+	{"fe80::1", false},
+	{"ff02::1", false},
+	{"::ffff:127.0.0.1", false},
+	{"::ffff:10.0.0.1", false},
+	{"64:ff9b::a00:1", false},
+}
+
+func TestPublicAddress(t *testing.T) {
+	for _, c := range publicAddressTests {
+		if got := publicAddress(net.ParseIP(c.ip)); got != c.public {
+			t.Errorf("publicAddress(%s) = %v, want %v", c.ip, got, c.public)
+		}
+	}
+}
+
+func TestPublicTransport(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	c := followRedirects(context.Background(), &http.Client{Transport: publicTransport}, ts.URL, 5)
+	if !strings.Contains(c.Error, errNonPublicAddress.Error()) {
+		t.Errorf("request to loopback address: %+v, want error", c)
+	}
+}
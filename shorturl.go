@@ -1,6 +1,7 @@
 package shorturl
 
 import (
+	"context"
 	"errors"
 	"net/url"
 	"strconv"
@@ -15,14 +16,62 @@ var (
 // Errors
 var (
 	ErrNotFound = errors.New("Shorturl not found")
+	ErrDisabled = errors.New("Shorturl disabled")
+	ErrReadOnly = errors.New("Shorturls are read only")
 )
 
+// Store is the storage of short urls used by the handlers. DB stores them in
+// the database, and Snapshot serves a read-only export of them.
+type Store interface {
+	Get(ctx context.Context, shortCode string) (*Shorturl, error)
+	Add(ctx context.Context, s *Shorturl) error
+	Hit(ctx context.Context, s *Shorturl) error
+	ByCreator(ctx context.Context, creator string, grace time.Duration) ([]CreatedShorturl, error)
+	Delete(ctx context.Context, s *Shorturl, grace time.Duration) error
+	SetDisabled(ctx context.Context, s *Shorturl, grace time.Duration) error
+}
+
 // Shorturl database structure
 type Shorturl struct {
-	ID    int64
-	URL   string
-	Host  string
-	Added time.Time
+	ID  int64
+	URL string
+	// Canonical is the normalized form of URL used to find duplicates.
+	Canonical string
+	Host      string
+	// Creator is the anonymous identity of the browser that added it.
+	Creator string
+	Added   time.Time
+	// FinalURL is where the URL redirected to when it was added, if it
+	// redirected.
+	FinalURL string
+	// Passthrough controls passing extra path and query to the target.
+	Passthrough Passthrough
+	// RedirectStatus is the status code of the redirect, or zero for the
+	// default of the service.
+	RedirectStatus int
+	// Hits is the number of redirects through the short url.
+	Hits int64
+	// Disabled is set when the creator has disabled the short url.
+	Disabled bool
+	// Check is the latest link check of the target, or nil if it has not
+	// been checked.
+	Check *LinkCheck
+	// MarkedDead is set by an admin when the target is gone.
+	MarkedDead bool
+	// ArchiveURL is an archived copy of the target, if there is one.
+	ArchiveURL string
+}
+
+// CreatedShorturl is a short url in the list of its creator.
+type CreatedShorturl struct {
+	*Shorturl
+	// Editable is set while the creator may still delete or disable it.
+	Editable bool
+}
+
+// parseUID parses the base-36 string representation of ID
+func parseUID(uid string) (int64, error) {
+	return strconv.ParseInt(uid, idBase, 32)
 }
 
 // UID is the base-36 string representation of ID
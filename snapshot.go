@@ -0,0 +1,355 @@
+package shorturl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotMagic starts a snapshot file and identifies its format version.
+const snapshotMagic = "YXFISNP1"
+
+// snapshotBackoff is how long redirects are served from the snapshot without
+// asking the database after it failed.
+const snapshotBackoff = 10 * time.Second
+
+// Snapshot record flags
+const (
+	snapshotDisabled = 1 << iota
+	snapshotDead
+)
+
+// Snapshot metrics, published with expvar
+var (
+	snapshotLinks     = expvar.NewInt("snapshot_links")
+	snapshotCreated   = expvar.NewInt("snapshot_created_unix")
+	snapshotFallbacks = expvar.NewInt("snapshot_fallbacks")
+	snapshotErrors    = expvar.NewInt("snapshot_refresh_errors")
+)
+
+func init() {
+	expvar.Publish("snapshot_age_seconds", expvar.Func(func() interface{} {
+		created := snapshotCreated.Value()
+		if created == 0 {
+			return nil
+		}
+		return time.Now().Unix() - created
+	}))
+}
+
+// Snapshot is a local copy of the short urls for redirecting while the
+// database is unavailable. It is kept in a file, so that it is available
+// even if the database is down when the server starts. A snapshot exported
+// to a file can also be served alone as a read-only Store.
+//
+// The file holds the sorted short url ids and offsets of their records,
+// followed by the records: the time added, flags and redirect status as
+// varints, and the URL, passthrough mode, archive URL and final URL as
+// length prefixed strings. It is loaded into memory as is and searched
+// with binary search.
+type Snapshot struct {
+	path string
+
+	mu    sync.RWMutex
+	table *snapshotTable
+	// dbFailed is the time of the latest database failure.
+	dbFailed time.Time
+}
+
+// snapshotTable is the content of a snapshot file.
+type snapshotTable struct {
+	created time.Time
+	ids     []uint32
+	offsets []uint32
+	data    []byte
+}
+
+// LoadSnapshot reads the snapshot file. If the file does not exist, the
+// snapshot is empty until updated.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	s := &Snapshot{path: path, table: &snapshotTable{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.table, err = parseSnapshot(b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s.publish()
+	return s, nil
+}
+
+// Get looks up the short url of the short code.
+func (s *Snapshot) Get(ctx context.Context, shortCode string) (*Shorturl, error) {
+	id, err := parseUID(shortCode)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	s.mu.RLock()
+	t := s.table
+	s.mu.RUnlock()
+	return t.get(id)
+}
+
+// lookup gets the short url for a redirect from the database, or from the
+// snapshot if the database fails. For snapshotBackoff after a failure the
+// snapshot is used first, so that redirects do not wait for the database to
+// time out again. Short urls newer than the snapshot are still looked up
+// from the database.
+func (s *Snapshot) lookup(ctx context.Context, db Store, shortCode string) (su *Shorturl, fromSnapshot bool, err error) {
+	s.mu.RLock()
+	down := time.Since(s.dbFailed) < snapshotBackoff
+	s.mu.RUnlock()
+	if down {
+		if su, err := s.Get(ctx, shortCode); err == nil {
+			snapshotFallbacks.Add(1)
+			return su, true, nil
+		}
+	}
+	su, err = db.Get(ctx, shortCode)
+	if err == nil || err == ErrNotFound || ctx.Err() != nil {
+		// A canceled request says nothing about the database.
+		return su, false, err
+	}
+	s.mu.Lock()
+	s.dbFailed = time.Now()
+	s.mu.Unlock()
+	if snap, serr := s.Get(ctx, shortCode); serr == nil {
+		log.Printf("redirecting from snapshot: %v", err)
+		snapshotFallbacks.Add(1)
+		return snap, true, nil
+	}
+	return nil, false, err
+}
+
+// Created is the time the snapshot was taken, or zero time if there is no
+// snapshot.
+func (s *Snapshot) Created() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.table.created
+}
+
+// Len is the number of short urls in the snapshot.
+func (s *Snapshot) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.table.ids)
+}
+
+// Add implements Store. A snapshot is read only.
+func (s *Snapshot) Add(ctx context.Context, _ *Shorturl) error { return ErrReadOnly }
+
+// Hit implements Store. Hits are not counted.
+func (s *Snapshot) Hit(ctx context.Context, _ *Shorturl) error { return nil }
+
+// ByCreator implements Store. The snapshot does not know the creators.
+func (s *Snapshot) ByCreator(ctx context.Context, creator string, grace time.Duration) ([]CreatedShorturl, error) {
+	return nil, nil
+}
+
+// Delete implements Store. A snapshot is read only.
+func (s *Snapshot) Delete(ctx context.Context, _ *Shorturl, grace time.Duration) error {
+	return ErrReadOnly
+}
+
+// SetDisabled implements Store. A snapshot is read only.
+func (s *Snapshot) SetDisabled(ctx context.Context, _ *Shorturl, grace time.Duration) error {
+	return ErrReadOnly
+}
+
+// Update exports the short urls from the database into the snapshot file
+// and loads it.
+func (s *Snapshot) Update(ctx context.Context, db *DB) error {
+	var buf bytes.Buffer
+	if err := ExportSnapshot(ctx, db, &buf); err != nil {
+		return err
+	}
+	t, err := parseSnapshot(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.table = t
+	s.mu.Unlock()
+	s.publish()
+	return nil
+}
+
+// Refresh updates the snapshot every interval, starting immediately. Each
+// update must finish within the interval. It never returns and is meant to
+// be run in its own goroutine.
+func (s *Snapshot) Refresh(db *DB, interval time.Duration) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := s.Update(ctx, db)
+		cancel()
+		if err != nil {
+			snapshotErrors.Add(1)
+			log.Printf("updating snapshot: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (s *Snapshot) publish() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshotLinks.Set(int64(len(s.table.ids)))
+	snapshotCreated.Set(s.table.created.Unix())
+}
+
+// ExportSnapshot writes a snapshot of all short urls in the database.
+func ExportSnapshot(ctx context.Context, db *DB, w io.Writer) error {
+	b := &snapshotBuilder{}
+	if err := db.Export(ctx, b.add); err != nil {
+		return err
+	}
+	return b.write(w, time.Now())
+}
+
+// snapshotBuilder builds a snapshot from short urls added in id order.
+type snapshotBuilder struct {
+	ids     []uint32
+	offsets []uint32
+	data    []byte
+}
+
+func (b *snapshotBuilder) add(s *Shorturl) error {
+	if n := len(b.ids); n > 0 && uint32(s.ID) <= b.ids[n-1] {
+		return errors.New("snapshot: short urls not in id order")
+	}
+	b.ids = append(b.ids, uint32(s.ID))
+	b.offsets = append(b.offsets, uint32(len(b.data)))
+	var flags uint64
+	if s.Disabled {
+		flags |= snapshotDisabled
+	}
+	if s.TargetDead() {
+		flags |= snapshotDead
+	}
+	b.data = appendUvarint(b.data, uint64(s.Added.Unix()))
+	b.data = appendUvarint(b.data, flags)
+	b.data = appendUvarint(b.data, uint64(s.RedirectStatus))
+	for _, str := range []string{s.URL, string(s.Passthrough), s.ArchiveURL, s.FinalURL} {
+		b.data = appendUvarint(b.data, uint64(len(str)))
+		b.data = append(b.data, str...)
+	}
+	return nil
+}
+
+func (b *snapshotBuilder) write(w io.Writer, created time.Time) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	header := []uint64{uint64(created.Unix()), uint64(len(b.ids)), uint64(len(b.data))}
+	for _, v := range header {
+		binary.Write(bw, binary.LittleEndian, v)
+	}
+	binary.Write(bw, binary.LittleEndian, b.ids)
+	binary.Write(bw, binary.LittleEndian, b.offsets)
+	bw.Write(b.data)
+	return bw.Flush()
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+var errSnapshotFormat = errors.New("not a valid snapshot file")
+
+func parseSnapshot(b []byte) (*snapshotTable, error) {
+	const headerSize = len(snapshotMagic) + 3*8
+	if len(b) < headerSize || string(b[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errSnapshotFormat
+	}
+	header := b[len(snapshotMagic):]
+	created := int64(binary.LittleEndian.Uint64(header))
+	count := binary.LittleEndian.Uint64(header[8:])
+	size := binary.LittleEndian.Uint64(header[16:])
+	b = b[headerSize:]
+	if uint64(len(b)) != 8*count+size {
+		return nil, errSnapshotFormat
+	}
+	t := &snapshotTable{
+		created: time.Unix(created, 0),
+		ids:     make([]uint32, count),
+		offsets: make([]uint32, count),
+		data:    b[8*count:],
+	}
+	for i := range t.ids {
+		t.ids[i] = binary.LittleEndian.Uint32(b[4*i:])
+		t.offsets[i] = binary.LittleEndian.Uint32(b[4*(int(count)+i):])
+		if t.offsets[i] > uint32(size) || (i > 0 && (t.ids[i] <= t.ids[i-1] || t.offsets[i] < t.offsets[i-1])) {
+			return nil, errSnapshotFormat
+		}
+	}
+	return t, nil
+}
+
+// get finds the short url by id.
+func (t *snapshotTable) get(id int64) (*Shorturl, error) {
+	i := sort.Search(len(t.ids), func(i int) bool { return int64(t.ids[i]) >= id })
+	if i == len(t.ids) || int64(t.ids[i]) != id {
+		return nil, ErrNotFound
+	}
+	end := len(t.data)
+	if i+1 < len(t.offsets) {
+		end = int(t.offsets[i+1])
+	}
+	r := bytes.NewReader(t.data[t.offsets[i]:end])
+	added, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errSnapshotFormat
+	}
+	flags, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errSnapshotFormat
+	}
+	status, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errSnapshotFormat
+	}
+	var strs [4]string
+	for j := range strs {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, errSnapshotFormat
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		strs[j] = string(b)
+	}
+	return &Shorturl{
+		ID:             id,
+		URL:            strs[0],
+		Added:          time.Unix(int64(added), 0),
+		Passthrough:    Passthrough(strs[1]),
+		ArchiveURL:     strs[2],
+		FinalURL:       strs[3],
+		RedirectStatus: int(status),
+		Disabled:       flags&snapshotDisabled != 0,
+		MarkedDead:     flags&snapshotDead != 0,
+	}, nil
+}
@@ -0,0 +1,186 @@
+package shorturl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSnapshot(t *testing.T, links ...*Shorturl) []byte {
+	b := &snapshotBuilder{}
+	for _, s := range links {
+		if err := b.add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := b.write(&buf, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshot(t *testing.T) {
+	added := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	links := []*Shorturl{
+		{ID: 10, URL: "https://example.com/", Added: added},
+		{ID: 1270, URL: "https://docs.example/", Added: added, Passthrough: PassthroughAppend, RedirectStatus: 308},
+		{ID: 1271, URL: "http://gone.example/", Added: added, Disabled: true, MarkedDead: true,
+			ArchiveURL: "https://archive.example/gone", FinalURL: "http://gone.example/home"},
+	}
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+	if err := ioutil.WriteFile(path, testSnapshot(t, links...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snap.Created(); !got.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Created = %v", got)
+	}
+	for _, want := range links {
+		got, err := snap.Get(context.Background(), want.UID())
+		if err != nil {
+			t.Errorf("Get(%q): %v", want.UID(), err)
+			continue
+		}
+		if got.ID != want.ID || got.URL != want.URL || !got.Added.Equal(want.Added) ||
+			got.Passthrough != want.Passthrough || got.RedirectStatus != want.RedirectStatus ||
+			got.Disabled != want.Disabled || got.TargetDead() != want.TargetDead() ||
+			got.ArchiveURL != want.ArchiveURL || got.FinalURL != want.FinalURL {
+			t.Errorf("Get(%q) = %+v, want %+v", want.UID(), got, want)
+		}
+	}
+	for _, code := range []string{"b", "zzzzzz", "-", "0"} {
+		if _, err := snap.Get(context.Background(), code); err != ErrNotFound {
+			t.Errorf("Get(%q) error = %v, want ErrNotFound", code, err)
+		}
+	}
+}
+
+func TestSnapshotMissingFile(t *testing.T) {
+	snap, err := LoadSnapshot(filepath.Join(os.TempDir(), "does-not-exist", "snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snap.Get(context.Background(), "a"); err != ErrNotFound {
+		t.Errorf("Get from empty snapshot: %v", err)
+	}
+}
+
+// invalidSnapshotTests break a valid snapshot file.
+var invalidSnapshotTests = []struct {
+	name    string
+	corrupt func(valid []byte) []byte
+}{
+	{"empty", func([]byte) []byte { return nil }},
+	{"magic", func(b []byte) []byte { return append([]byte("NOTASNAP"), b[8:]...) }},
+	{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+}
+
+func TestParseSnapshotInvalid(t *testing.T) {
+	valid := testSnapshot(t, &Shorturl{ID: 1, URL: "https://example.com/"}, &Shorturl{ID: 2, URL: "https://example.org/"})
+	for _, c := range invalidSnapshotTests {
+		if _, err := parseSnapshot(c.corrupt(valid)); err == nil {
+			t.Errorf("%s: parseSnapshot succeeded", c.name)
+		}
+	}
+	b := &snapshotBuilder{}
+	b.add(&Shorturl{ID: 2})
+	if err := b.add(&Shorturl{ID: 1}); err == nil {
+		t.Error("adding short urls out of order succeeded")
+	}
+}
+
+var snapshotHandlerTests = []struct {
+	method, path string
+	status       int
+}{
+	{"GET", "/za", http.StatusFound},
+	{"GET", "/zb", http.StatusGone},
+	{"GET", "/zc", http.StatusNotFound},
+	{"GET", "/p/za", http.StatusOK},
+	{"GET", "/mine", http.StatusOK},
+}
+
+func TestHandlerFromSnapshot(t *testing.T) {
+	table, err := parseSnapshot(testSnapshot(t,
+		&Shorturl{ID: 1270, URL: "https://example.com/"},
+		&Shorturl{ID: 1271, URL: "https://example.org/", Disabled: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler(&Snapshot{table: table}, testConfig(t))
+	for _, c := range snapshotHandlerTests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, "http://yx.fi"+c.path, nil))
+		if w.Code != c.status {
+			t.Errorf("%s %s: status %d, want %d", c.method, c.path, w.Code, c.status)
+		}
+	}
+}
+
+// failingStore is a Store whose database is down.
+type failingStore struct {
+	Store
+	gets int
+}
+
+func (f *failingStore) Get(ctx context.Context, shortCode string) (*Shorturl, error) {
+	f.gets++
+	return nil, errors.New("database is down")
+}
+
+func TestSnapshotLookupBackoff(t *testing.T) {
+	table, err := parseSnapshot(testSnapshot(t, &Shorturl{ID: 1270, URL: "https://example.com/"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := &Snapshot{table: table}
+	db := &failingStore{}
+	ctx := context.Background()
+	if s, fromSnapshot, err := snap.lookup(ctx, db, "za"); err != nil || !fromSnapshot || s.ID != 1270 {
+		t.Fatalf("lookup = %+v, %v, %v; want from snapshot", s, fromSnapshot, err)
+	}
+	if _, _, err := snap.lookup(ctx, db, "za"); err != nil || db.gets != 1 {
+		t.Errorf("lookup after failure asked the database %d times, want once", db.gets)
+	}
+	// Short urls missing from the snapshot are still looked up.
+	if _, _, err := snap.lookup(ctx, db, "zc"); err == nil || db.gets != 2 {
+		t.Errorf("lookup missing from snapshot = %v with %d database gets, want error after 2", err, db.gets)
+	}
+	snap.dbFailed = time.Now().Add(-snapshotBackoff)
+	if snap.lookup(ctx, db, "za"); db.gets != 3 {
+		t.Errorf("lookup after backoff did not ask the database")
+	}
+}
+
+func TestSnapshotLookupCanceled(t *testing.T) {
+	table, err := parseSnapshot(testSnapshot(t, &Shorturl{ID: 1270, URL: "https://example.com/"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := &Snapshot{table: table}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := snap.lookup(ctx, &failingStore{}, "za"); err == nil {
+		t.Error("lookup with canceled request succeeded, want error")
+	}
+	if !snap.dbFailed.IsZero() {
+		t.Error("canceled request marked the database failed")
+	}
+}
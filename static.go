@@ -0,0 +1,225 @@
+package shorturl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/joneskoo/shorturl-go/assets"
+)
+
+// staticImmutableMaxAge is the cache lifetime of fingerprinted static files.
+// Their content never changes, as a change gives them a new name.
+const staticImmutableMaxAge = 365 * 24 * time.Hour
+
+// staticEncodings are the precompressed encodings of static files, in order
+// of preference.
+var staticEncodings = []struct {
+	name     string
+	compress func([]byte) ([]byte, error)
+}{
+	{"br", compressBrotli},
+	{"gzip", compressGzip},
+}
+
+// static are the embedded static files served under /static/.
+var static = mustLoadStatic(assets.FS, "static")
+
+// staticFile is a file served under /static/, with its precompressed
+// variants.
+type staticFile struct {
+	name        string
+	contentType string
+	// fingerprinted is the name with a hash of the content added, e.g.
+	// style.0123456789.css.
+	fingerprinted string
+	// variants are the precompressed content in order of preference,
+	// followed by the uncompressed content.
+	variants []staticVariant
+}
+
+type staticVariant struct {
+	encoding string
+	etag     string
+	data     []byte
+}
+
+// staticFiles are the static files by both their plain and fingerprinted
+// names.
+type staticFiles map[string]*staticFile
+
+func mustLoadStatic(fsys fs.FS, dir string) staticFiles {
+	files, err := loadStatic(fsys, dir)
+	if err != nil {
+		log.Fatalf("Loading static files: %v", err)
+	}
+	return files
+}
+
+// loadStatic reads the files under dir and compresses them.
+func loadStatic(fsys fs.FS, dir string) (staticFiles, error) {
+	files := make(staticFiles)
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		f, err := newStaticFile(strings.TrimPrefix(p, dir+"/"), data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		files[f.name] = f
+		files[f.fingerprinted] = f
+		return nil
+	})
+	return files, err
+}
+
+func newStaticFile(name string, data []byte) (*staticFile, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:10]
+	ext := path.Ext(name)
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	f := &staticFile{
+		name:          name,
+		contentType:   contentType,
+		fingerprinted: strings.TrimSuffix(name, ext) + "." + hash + ext,
+	}
+	for _, enc := range staticEncodings {
+		compressed, err := enc.compress(data)
+		if err != nil {
+			return nil, err
+		}
+		// Not worth it for files that do not compress.
+		if len(compressed) >= len(data) {
+			continue
+		}
+		f.variants = append(f.variants, staticVariant{
+			encoding: enc.name,
+			etag:     `"` + hash + "-" + enc.name + `"`,
+			data:     compressed,
+		})
+	}
+	f.variants = append(f.variants, staticVariant{etag: `"` + hash + `"`, data: data})
+	return f, nil
+}
+
+// variant picks the preferred variant the client accepts.
+func (f *staticFile) variant(acceptEncoding string) staticVariant {
+	for _, v := range f.variants {
+		if v.encoding == "" || acceptsEncoding(acceptEncoding, v.encoding) {
+			return v
+		}
+	}
+	return f.variants[len(f.variants)-1]
+}
+
+// url is the path of the fingerprinted file, which can be cached forever.
+func (files staticFiles) url(name string) string {
+	if f, ok := files[name]; ok {
+		name = f.fingerprinted
+	}
+	return "/static/" + name
+}
+
+// staticHandler serves the static files under /static/. Fingerprinted names
+// are cached forever, while plain names are revalidated with the ETag on
+// every use.
+func staticHandler(files staticFiles) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/static/")
+		f, ok := files[name]
+		if !ok {
+			(&Error{Status: http.StatusNotFound}).ServeHTTP(w, req)
+			return
+		}
+		v := f.variant(req.Header.Get("Accept-Encoding"))
+		h := w.Header()
+		if name == f.fingerprinted {
+			h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(staticImmutableMaxAge.Seconds())))
+		} else {
+			h.Set("Cache-Control", "no-cache")
+		}
+		h.Set("Vary", "Accept-Encoding")
+		h.Set("Content-Type", f.contentType)
+		h.Set("ETag", v.etag)
+		if v.encoding != "" {
+			h.Set("Content-Encoding", v.encoding)
+		}
+		http.ServeContent(w, req, f.name, time.Time{}, bytes.NewReader(v.data))
+	})
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header value allows
+// the content coding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		if coding, q := parseAcceptItem(part); strings.EqualFold(coding, encoding) {
+			return q > 0
+		}
+	}
+	return false
+}
+
+// parseAcceptItem parses an item of an Accept-* header, such as "br;q=0.5",
+// into the value and its quality. The quality is 1 if not given, and 0 if
+// it is not valid.
+func parseAcceptItem(item string) (string, float64) {
+	params := strings.Split(item, ";")
+	q := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			var err error
+			if q, err = strconv.ParseFloat(param[len("q="):], 64); err != nil {
+				q = 0
+			}
+		}
+	}
+	return strings.TrimSpace(params[0]), q
+}
+
+func compressGzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compressBrotli(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,82 @@
+package shorturl
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var staticHandlerTests = []struct {
+	fingerprinted  bool
+	acceptEncoding string
+	encoding       string
+	cache          string
+}{
+	{true, "gzip, deflate, br", "br", "immutable"},
+	{true, "gzip, br;q=0", "gzip", "immutable"},
+	{false, "", "", "no-cache"},
+}
+
+func TestStaticHandler(t *testing.T) {
+	url := static.url("style.css")
+	if url == "/static/style.css" || !strings.HasSuffix(url, ".css") {
+		t.Fatalf("static url = %q, want fingerprinted name", url)
+	}
+	for _, c := range staticHandlerTests {
+		path := "/static/style.css"
+		if c.fingerprinted {
+			path = url
+		}
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		staticHandler(static).ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Errorf("GET %s: status %d", path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Encoding"); got != c.encoding {
+			t.Errorf("GET %s with %q: Content-Encoding = %q, want %q", path, c.acceptEncoding, got, c.encoding)
+		}
+		if got := w.Header().Get("Cache-Control"); !strings.Contains(got, c.cache) {
+			t.Errorf("GET %s: Cache-Control = %q, want %s", path, got, c.cache)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/css") {
+			t.Errorf("GET %s: Content-Type = %q", path, got)
+		}
+
+		req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w = httptest.NewRecorder()
+		staticHandler(static).ServeHTTP(w, req)
+		if w.Code != 304 {
+			t.Errorf("GET %s with matching ETag: status %d, want 304", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	staticHandler(static).ServeHTTP(w, httptest.NewRequest("GET", "/static/missing.css", nil))
+	if w.Code != 404 {
+		t.Errorf("GET missing file: status %d, want 404", w.Code)
+	}
+}
+
+var acceptsEncodingTests = []struct {
+	header, encoding string
+	want             bool
+}{
+	{"", "gzip", false},
+	{"gzip", "gzip", true},
+	{"deflate, GZIP", "gzip", true},
+	{"gzip;q=0", "gzip", false},
+	{"gzip;q=0.5, br", "gzip", true},
+	{"br;q=0.0", "br", false},
+	{"x-gzip", "gzip", false},
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, c := range acceptsEncodingTests {
+		if got := acceptsEncoding(c.header, c.encoding); got != c.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", c.header, c.encoding, got, c.want)
+		}
+	}
+}
@@ -1,54 +1,211 @@
 package shorturl
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/joneskoo/shorturl-go/assets"
 )
 
+// templateSets are the page templates, each parsed together with the
+// templates it uses.
+var templateSets = [][]string{
+	{"error.html", "layout.html"},
+	{"index.html", "layout.html"},
+	{"preview.html", "layout.html"},
+	{"prefs.html", "layout.html"},
+	{"mine.html", "layout.html"},
+}
+
+// errorTemplateSets are the templates of the error pages by status, e.g.
+// 404.html. They are optional, as error.html is used for statuses without
+// one.
+func errorTemplateSets() [][]string {
+	var sets [][]string
+	for status := range errorPages {
+		sets = append(sets, []string{errorTemplate(status), "layout.html"})
+	}
+	return sets
+}
+
+func errorTemplate(status int) string {
+	return strconv.Itoa(status) + ".html"
+}
+
 func init() {
-	err := parseHTMLTemplates([][]string{
-		{"error.html", "layout.html"},
-		{"index.html", "layout.html"},
-		{"404.html", "layout.html"},
-		{"preview.html", "layout.html"},
-	})
-	if err != nil {
+	if err := LoadTemplates(""); err != nil {
 		log.Fatalf("Parsing HTML templates: %v", err)
 	}
 }
 
-var templates = map[string]interface {
+type executer interface {
 	Execute(io.Writer, interface{}) error
-}{}
+}
+
+// templates are the parsed page templates by language and name. The map is
+// replaced, not modified, when templates are reloaded.
+var (
+	templatesMu sync.RWMutex
+	templates   = map[string]map[string]executer{}
+)
 
 var htmlTemplateFuncs = template.FuncMap{
-	"truncate":   truncate,
-	"formattime": formatTime,
-	"upper":      strings.ToUpper,
+	"truncate": truncate,
+	"upper":    strings.ToUpper,
+	"hours":    hours,
+	"static":   static.url,
+}
+
+func lookupTemplate(name, lang string) (executer, bool) {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	t, ok := templates[lang][name]
+	return t, ok
+}
+
+func hasTemplate(name string) bool {
+	_, ok := lookupTemplate(name, defaultLanguage)
+	return ok
 }
 
-func parseHTMLTemplates(sets [][]string) error {
-	for _, set := range sets {
+// LoadTemplates parses the page templates. Files in dir, if it is given, are
+// used instead of the embedded templates of the same name, e.g. a modified
+// layout.html. The templates in use are kept if parsing fails.
+func LoadTemplates(dir string) error {
+	parsed, err := parseHTMLTemplates(dir)
+	if err != nil {
+		return err
+	}
+	templatesMu.Lock()
+	templates = parsed
+	templatesMu.Unlock()
+	return nil
+}
+
+// CheckTemplates checks that the templates parse with the files in dir, and
+// that dir has no files that are not templates, such as misspelled names.
+func CheckTemplates(dir string) error {
+	if _, err := parseHTMLTemplates(dir); err != nil || dir == "" {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, set := range append(templateSets, errorTemplateSets()...) {
+		for _, name := range set {
+			known[name] = true
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if !fi.IsDir() && !known[fi.Name()] {
+			return fmt.Errorf("%s is not a template", filepath.Join(dir, fi.Name()))
+		}
+	}
+	return nil
+}
+
+// WatchTemplates reloads the templates when files in dir change, checking
+// every interval. It never returns and is meant to be run in its own
+// goroutine.
+func WatchTemplates(dir string, interval time.Duration) {
+	state := templatesState(dir)
+	for range time.Tick(interval) {
+		current := templatesState(dir)
+		if current == state {
+			continue
+		}
+		state = current
+		if err := LoadTemplates(dir); err != nil {
+			log.Printf("reloading templates: %v", err)
+			continue
+		}
+		log.Printf("Reloaded templates from %s", dir)
+	}
+}
+
+// templatesState describes the names and modification times of the files in
+// dir, so that any change to them changes it.
+func templatesState(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err.Error()
+	}
+	var b strings.Builder
+	for _, fi := range files {
+		fmt.Fprintf(&b, "%s %d %d\n", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// parseHTMLTemplates parses the template sets for each language, with the
+// text functions translating to the language. Error pages without a
+// template are skipped.
+func parseHTMLTemplates(dir string) (map[string]map[string]executer, error) {
+	parsed := make(map[string]map[string]executer)
+	for _, lang := range languages {
+		parsed[lang.Tag] = make(map[string]executer)
+	}
+	parse := func(set []string, optional bool) error {
 		templateName := set[0]
-		t := template.New(templateName).Funcs(htmlTemplateFuncs)
+		base := template.New(templateName).Funcs(htmlTemplateFuncs).Funcs(languageFuncs(defaultLanguage))
 		for _, assetName := range set {
-			asset, err := assets.Asset("templates/" + assetName)
+			asset, err := readTemplate(dir, assetName)
+			if optional && assetName == templateName && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
 			if err != nil {
 				return err
 			}
-			if _, err := t.Parse(string(asset)); err != nil {
+			if _, err := base.Parse(string(asset)); err != nil {
+				return fmt.Errorf("%s: %v", templateName, err)
+			}
+		}
+		for _, lang := range languages {
+			t, err := base.Clone()
+			if err != nil {
 				return err
 			}
+			parsed[lang.Tag][templateName] = t.Funcs(languageFuncs(lang.Tag))
 		}
-		templates[templateName] = t
+		return nil
 	}
-	return nil
+	for _, set := range templateSets {
+		if err := parse(set, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, set := range errorTemplateSets() {
+		if err := parse(set, true); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// readTemplate reads the template from dir, or the embedded template if dir
+// is empty or does not have it.
+func readTemplate(dir, name string) ([]byte, error) {
+	if dir != "" {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if !os.IsNotExist(err) {
+			return b, err
+		}
+	}
+	return fs.ReadFile(assets.FS, "templates/"+name)
 }
 
 // truncate limits the string to 25 unicode characters
@@ -69,6 +226,16 @@ func truncate(str string, limit int) string {
 	return str[:n] + "…"
 }
 
-func formatTime(t *time.Time, format string) string {
+// hours returns the duration in whole hours.
+func hours(d time.Duration) int {
+	return int(d / time.Hour)
+}
+
+// formatTime formats the time in the given location, or as is if the
+// location is nil.
+func formatTime(t *time.Time, format string, loc *time.Location) string {
+	if loc != nil {
+		return t.In(loc).Format(format)
+	}
 	return t.Format(format)
 }
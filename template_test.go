@@ -0,0 +1,68 @@
+package shorturl
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joneskoo/shorturl-go/assets"
+)
+
+func TestLoadTemplatesOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer LoadTemplates("")
+
+	layout, err := fs.ReadFile(assets.FS, "templates/layout.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := strings.Replace(string(layout), "</body>", "<p>abuse@example.com</p></body>", 1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "layout.html"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	errorNotFound.ServeHTTP(w, httptest.NewRequest("GET", "http://yx.fi/", nil))
+	if !strings.Contains(w.Body.String(), "abuse@example.com") {
+		t.Error("page does not use the template from the overlay directory")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "preview.html"), []byte("{{ .Nope "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = LoadTemplates(dir)
+	if err == nil || !strings.Contains(err.Error(), "preview.html") {
+		t.Errorf("LoadTemplates() error = %v, want error naming preview.html", err)
+	}
+	if _, ok := lookupTemplate("preview.html", "en"); !ok {
+		t.Error("templates were not kept after a failed reload")
+	}
+}
+
+func TestCheckTemplatesUnknownFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := CheckTemplates(dir); err != nil {
+		t.Errorf("CheckTemplates(empty dir) = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "layuot.html"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckTemplates(dir); err == nil {
+		t.Error("CheckTemplates() accepted a misspelled template name")
+	}
+}